@@ -2,14 +2,28 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,6 +34,15 @@ import (
 // This variable is used by tests to mock the exec.Command function.
 var execCommand = exec.Command
 
+// These variables are used by tests to mock the network for diagnostics.
+var (
+	lookupHost  = net.LookupHost
+	dialTimeout = net.DialTimeout
+)
+
+// diagnosticTimeout bounds each network step of the diagnostic ladder.
+const diagnosticTimeout = 10 * time.Second
+
 // --- Configuration ---
 var (
 // rsyncURL is the base URL of the rsync server to monitor.
@@ -30,199 +53,2635 @@ rsyncURL = "rsync://sagres.c3sl.ufpr.br/"
 // Can be overridden by the POLLING_INTERVAL_SECONDS environment variable.
 pollingInterval = 5 * time.Minute
 
-// port for the HTTP server. Can be overridden by the PORT environment variable.
-serverPort = "8080"
-)
+// port for the HTTP server. Can be overridden by the PORT environment variable.
+serverPort = "8080"
+
+// canaryFiles maps a module name to the small file transferred on every check
+// to prove that the module actually serves data, not just that it is listed.
+// Can be set with the CANARY_FILES environment variable.
+canaryFiles = map[string]canaryConfig{}
+
+// benchmarkFile is the file, relative to rsyncURL, transferred periodically to
+// measure throughput. Empty disables the probe. Set with BENCHMARK_FILE.
+benchmarkFile = ""
+
+// benchmarkInterval is how often the throughput probe runs. It is much less
+// frequent than module checks because it transfers a larger file.
+// Can be overridden by the BENCHMARK_INTERVAL_SECONDS environment variable.
+benchmarkInterval = 1 * time.Hour
+
+// ipFamilies lists the address families each module is additionally checked
+// over, independently of the default check. Set with CHECK_IP_FAMILIES.
+ipFamilies []string
+
+// moduleCredentials maps a module protected by "auth users" to the user and
+// password source used to check it. Set with RSYNC_CREDENTIALS.
+moduleCredentials = map[string]credentials{}
+
+// discoveryInterval is how often the module list is fetched again, so new
+// modules and changed descriptions are picked up without a restart.
+// Can be overridden by the DISCOVERY_INTERVAL_SECONDS environment variable.
+discoveryInterval = 1 * time.Hour
+
+// maxConcurrentPerServer caps how many checks run against the same server at
+// once, to stay below the daemon's "max connections". Can be overridden by
+// the MAX_CONCURRENT_CHECKS environment variable.
+maxConcurrentPerServer = 4
+
+// scheduleJitter is the fraction of its interval over which the first run of
+// each job is spread (at most schedulerStartupWindow), so checks don't all
+// fire at the same moment. Can be
+// overridden by the SCHEDULE_JITTER environment variable (0 to 1).
+scheduleJitter = 1.0
+
+// moduleSelection decides which discovered modules are monitored. Set with
+// MODULE_INCLUDE, MODULE_EXCLUDE and MODULES.
+moduleSelection = &moduleFilter{}
+
+// sshTargets maps a name to a "user@host:/path" target that is only reachable
+// with rsync over ssh, not through the daemon. Set with SSH_TARGETS.
+sshTargets = map[string]string{}
+
+// sshIdentityFile, sshKnownHostsFile and sshPort configure the ssh connection
+// for sshTargets. Set with SSH_IDENTITY_FILE, SSH_KNOWN_HOSTS_FILE and SSH_PORT.
+sshIdentityFile   = ""
+sshKnownHostsFile = ""
+sshPort           = "22"
+
+// stateFile is where check results are saved at shutdown and loaded from at
+// startup, so a restart does not wipe the uptime history. Empty disables
+// persistence. Set with STATE_FILE.
+stateFile = ""
+
+// refreshMinInterval is the minimum time between two refreshes of the module
+// list requested with POST /refresh. Can be overridden by the
+// REFRESH_MIN_INTERVAL_SECONDS environment variable.
+refreshMinInterval = 1 * time.Minute
+
+// shutdownTimeout is how long shutdown waits for checks in progress before
+// killing their rsync processes. It stays below Docker's default 10s grace
+// period. Can be overridden by the SHUTDOWN_TIMEOUT_SECONDS environment variable.
+shutdownTimeout = 8 * time.Second
+
+// historyRetention is how long check and throughput results are kept, and so
+// the longest window /status can serve. Can be overridden by the
+// HISTORY_RETENTION environment variable, e.g. "7d" or "72h".
+historyRetention = 24 * time.Hour
+)
+
+// motdIdleTimeout is how long the daemon may stay silent before the MOTD is
+// considered complete. The daemon sends it right after the greeting and then
+// waits for a module name, so silence marks its end.
+var motdIdleTimeout = 2 * time.Second
+
+// This variable is used by tests to substitute a fake ssh wrapper.
+var sshCommand = "ssh"
+
+// rsyncSSLCommand is the helper shipped with rsync >= 3.2 that tunnels the
+// daemon protocol over TLS. It is used for rsyncs:// URLs.
+var rsyncSSLCommand = "rsync-ssl"
+
+// TLS settings for rsyncs:// URLs.
+var (
+	// tlsCAFile is a PEM bundle used instead of the system roots to validate
+	// the daemon's certificate. Set with RSYNC_TLS_CA_FILE.
+	tlsCAFile = ""
+
+	// certWarningDays is how close to expiry a certificate must be to be
+	// reported as "warning". Set with CERT_EXPIRY_WARNING_DAYS.
+	certWarningDays = 14
+)
+
+// defaultTLSPort is the port stunnel front-ends and rsync-ssl use for rsyncs://.
+const defaultTLSPort = "874"
+
+// init runs before main() to load configuration from environment variables.
+func init() {
+   if url := os.Getenv("RSYNC_URL"); url != "" {
+	  rsyncURL = url
+	  log.Printf("Using custom rsync URL from environment: %s", rsyncURL)
+   }
+
+   if intervalStr := os.Getenv("POLLING_INTERVAL_SECONDS"); intervalStr != "" {
+	  if intervalSec, err := strconv.Atoi(intervalStr); err == nil && intervalSec > 0 {
+		 pollingInterval = time.Duration(intervalSec) * time.Second
+		 log.Printf("Using custom polling interval from environment: %v", pollingInterval)
+	  } else {
+		 log.Printf("WARN: Invalid POLLING_INTERVAL_SECONDS value '%s'. Using default.", intervalStr)
+	  }
+   }
+
+   if port := os.Getenv("PORT"); port != "" {
+	  serverPort = port
+	  log.Printf("Using custom server port from environment: %s", serverPort)
+   }
+
+	if spec := os.Getenv("CANARY_FILES"); spec != "" {
+		if files, err := parseCanaryFiles(spec); err == nil {
+			canaryFiles = files
+			log.Printf("Using canary files for %d modules from environment", len(canaryFiles))
+		} else {
+			log.Printf("WARN: Invalid CANARY_FILES value: %v. Canary checks disabled.", err)
+		}
+	}
+
+	if file := os.Getenv("BENCHMARK_FILE"); file != "" {
+		benchmarkFile = strings.TrimPrefix(file, "/")
+		log.Printf("Using benchmark file from environment: %s", benchmarkFile)
+	}
+
+	if intervalStr := os.Getenv("BENCHMARK_INTERVAL_SECONDS"); intervalStr != "" {
+		if intervalSec, err := strconv.Atoi(intervalStr); err == nil && intervalSec > 0 {
+			benchmarkInterval = time.Duration(intervalSec) * time.Second
+			log.Printf("Using custom benchmark interval from environment: %v", benchmarkInterval)
+		} else {
+			log.Printf("WARN: Invalid BENCHMARK_INTERVAL_SECONDS value '%s'. Using default.", intervalStr)
+		}
+	}
+
+	if spec := os.Getenv("CHECK_IP_FAMILIES"); spec != "" {
+		if families, err := parseIPFamilies(spec); err == nil {
+			ipFamilies = families
+			log.Printf("Checking address families from environment: %v", ipFamilies)
+		} else {
+			log.Printf("WARN: Invalid CHECK_IP_FAMILIES value: %v. Per-family checks disabled.", err)
+		}
+	}
+
+	if spec := os.Getenv("RSYNC_CREDENTIALS"); spec != "" {
+		if creds, err := parseCredentials(spec); err == nil {
+			moduleCredentials = creds
+			log.Printf("Using credentials for %d modules from environment", len(moduleCredentials))
+		} else {
+			// The error never contains the password itself, only its source.
+			log.Printf("WARN: Invalid RSYNC_CREDENTIALS value: %v. Authenticated checks disabled.", err)
+		}
+	}
+
+	if intervalStr := os.Getenv("DISCOVERY_INTERVAL_SECONDS"); intervalStr != "" {
+		if intervalSec, err := strconv.Atoi(intervalStr); err == nil && intervalSec > 0 {
+			discoveryInterval = time.Duration(intervalSec) * time.Second
+			log.Printf("Using custom discovery interval from environment: %v", discoveryInterval)
+		} else {
+			log.Printf("WARN: Invalid DISCOVERY_INTERVAL_SECONDS value '%s'. Using default.", intervalStr)
+		}
+	}
+
+	if maxStr := os.Getenv("MAX_CONCURRENT_CHECKS"); maxStr != "" {
+		if max, err := strconv.Atoi(maxStr); err == nil && max > 0 {
+			maxConcurrentPerServer = max
+			log.Printf("Using custom concurrency limit from environment: %d checks per server", maxConcurrentPerServer)
+		} else {
+			log.Printf("WARN: Invalid MAX_CONCURRENT_CHECKS value '%s'. Using default.", maxStr)
+		}
+	}
+
+	if jitterStr := os.Getenv("SCHEDULE_JITTER"); jitterStr != "" {
+		if jitter, err := strconv.ParseFloat(jitterStr, 64); err == nil && jitter >= 0 && jitter <= 1 {
+			scheduleJitter = jitter
+			log.Printf("Using custom schedule jitter from environment: %.2f", scheduleJitter)
+		} else {
+			log.Printf("WARN: Invalid SCHEDULE_JITTER value '%s'. Using default.", jitterStr)
+		}
+	}
+
+	include, exclude, explicit := os.Getenv("MODULE_INCLUDE"), os.Getenv("MODULE_EXCLUDE"), os.Getenv("MODULES")
+	if include != "" || exclude != "" || explicit != "" {
+		if filter, err := parseModuleFilter(include, exclude, explicit); err == nil {
+			moduleSelection = filter
+			log.Printf("Using module filter from environment: include=%q exclude=%q modules=%q", include, exclude, explicit)
+		} else {
+			log.Fatalf("FATAL: Invalid module filter: %v", err)
+		}
+	}
+
+	if spec := os.Getenv("SSH_TARGETS"); spec != "" {
+		if targets, err := parseSSHTargets(spec); err == nil {
+			sshTargets = targets
+			log.Printf("Using %d ssh targets from environment", len(sshTargets))
+		} else {
+			log.Printf("WARN: Invalid SSH_TARGETS value: %v. SSH checks disabled.", err)
+		}
+	}
+
+	if file := os.Getenv("SSH_IDENTITY_FILE"); file != "" {
+		sshIdentityFile = file
+	}
+	if file := os.Getenv("SSH_KNOWN_HOSTS_FILE"); file != "" {
+		sshKnownHostsFile = file
+	}
+	if file := os.Getenv("RSYNC_TLS_CA_FILE"); file != "" {
+		tlsCAFile = file
+		log.Printf("Using CA bundle from environment: %s", tlsCAFile)
+	}
+
+	if daysStr := os.Getenv("CERT_EXPIRY_WARNING_DAYS"); daysStr != "" {
+		if days, err := strconv.Atoi(daysStr); err == nil && days >= 0 {
+			certWarningDays = days
+		} else {
+			log.Printf("WARN: Invalid CERT_EXPIRY_WARNING_DAYS value '%s'. Using default.", daysStr)
+		}
+	}
+
+	if port := os.Getenv("SSH_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil && p > 0 && p < 65536 {
+			sshPort = port
+		} else {
+			log.Printf("WARN: Invalid SSH_PORT value '%s'. Using default.", port)
+		}
+	}
+
+	if file := os.Getenv("STATE_FILE"); file != "" {
+		stateFile = file
+		log.Printf("Using state file from environment: %s", stateFile)
+	}
+
+	if intervalStr := os.Getenv("REFRESH_MIN_INTERVAL_SECONDS"); intervalStr != "" {
+		if interval, err := strconv.Atoi(intervalStr); err == nil && interval >= 0 {
+			refreshMinInterval = time.Duration(interval) * time.Second
+			log.Printf("Using custom refresh rate limit from environment: %v", refreshMinInterval)
+		} else {
+			log.Printf("WARN: Invalid REFRESH_MIN_INTERVAL_SECONDS value '%s'. Using default.", intervalStr)
+		}
+	}
+
+	if timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if timeout, err := strconv.Atoi(timeoutStr); err == nil && timeout >= 0 {
+			shutdownTimeout = time.Duration(timeout) * time.Second
+			log.Printf("Using custom shutdown timeout from environment: %v", shutdownTimeout)
+		} else {
+			log.Printf("WARN: Invalid SHUTDOWN_TIMEOUT_SECONDS value '%s'. Using default.", timeoutStr)
+		}
+	}
+
+	if retentionStr := os.Getenv("HISTORY_RETENTION"); retentionStr != "" {
+		if retention, err := parseSpan(retentionStr); err == nil && retention > 0 {
+			historyRetention = retention
+			log.Printf("Using custom history retention from environment: %v", historyRetention)
+		} else {
+			log.Printf("WARN: Invalid HISTORY_RETENTION value '%s'. Using default.", retentionStr)
+		}
+	}
+}
+
+// parseSpan parses a Go duration such as "90m" or "72h", or a number of days
+// such as "7d".
+func parseSpan(spec string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(spec, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days in '%s'", spec)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(spec)
+}
+
+// moduleFilter selects modules by name. Explicitly listed modules are always
+// monitored, even if the daemon does not list them. Otherwise a module must
+// match an include pattern (when there are any) and no exclude pattern.
+type moduleFilter struct {
+	include  []namePattern
+	exclude  []namePattern
+	explicit []string
+}
+
+// namePattern reports whether a module name matches a pattern.
+type namePattern func(name string) bool
+
+// parseModuleFilter builds a filter from comma-separated pattern lists. A
+// pattern prefixed with "re:" is a regular expression matched against the
+// whole name; anything else is a shell glob such as "debian-*".
+func parseModuleFilter(include, exclude, explicit string) (*moduleFilter, error) {
+	f := &moduleFilter{}
+	var err error
+	if f.include, err = parsePatterns(include); err != nil {
+		return nil, fmt.Errorf("MODULE_INCLUDE: %w", err)
+	}
+	if f.exclude, err = parsePatterns(exclude); err != nil {
+		return nil, fmt.Errorf("MODULE_EXCLUDE: %w", err)
+	}
+	for _, name := range strings.Split(explicit, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !isValidModulePath(name) {
+			return nil, fmt.Errorf("MODULES: invalid module name %q", name)
+		}
+		f.explicit = append(f.explicit, name)
+	}
+	return f, nil
+}
+
+func parsePatterns(spec string) ([]namePattern, error) {
+	var patterns []namePattern
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(p, "re:"); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp %q: %w", expr, err)
+			}
+			patterns = append(patterns, re.MatchString)
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", p, err)
+		}
+		glob := p
+		patterns = append(patterns, func(name string) bool {
+			matched, _ := path.Match(glob, name)
+			return matched
+		})
+	}
+	return patterns, nil
+}
+
+// allows reports whether a module should be monitored.
+func (f *moduleFilter) allows(name string) bool {
+	for _, e := range f.explicit {
+		if e == name {
+			return true
+		}
+	}
+	if len(f.include) > 0 && !matchesAny(f.include, name) {
+		return false
+	}
+	return !matchesAny(f.exclude, name)
+}
+
+func matchesAny(patterns []namePattern, name string) bool {
+	for _, match := range patterns {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSSHTargets parses a SSH_TARGETS specification of the form
+// "name=user@host:/path,...". Names follow the same rules as module names.
+func parseSSHTargets(spec string) (map[string]string, error) {
+	targets := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(entry, "=")
+		host, dir, hasPath := strings.Cut(target, ":")
+		if !ok || !isValidModulePath(name) || !hasPath || host == "" || strings.HasSuffix(host, "@") || dir == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected name=user@host:/path", entry)
+		}
+		targets[name] = target
+	}
+	return targets, nil
+}
+
+// credentials are the rsync user and where to read its password from:
+// either a password file (passed with --password-file) or an environment
+// variable (passed to rsync as RSYNC_PASSWORD). The password itself is never
+// stored, logged or put on the command line.
+type credentials struct {
+	User         string
+	PasswordFile string
+	PasswordEnv  string
+}
+
+// parseCredentials parses a RSYNC_CREDENTIALS specification of the form
+// "module=user:file:/path/to/secret,module=user:env:VARIABLE".
+func parseCredentials(spec string) (map[string]credentials, error) {
+	creds := make(map[string]credentials)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		module, rest, ok := strings.Cut(entry, "=")
+		fields := strings.SplitN(rest, ":", 3)
+		if !ok || !isValidModulePath(module) || len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("invalid entry for module %q: expected module=user:file:/path or module=user:env:VARIABLE", module)
+		}
+		c := credentials{User: fields[0]}
+		switch fields[1] {
+		case "file":
+			c.PasswordFile = fields[2]
+		case "env":
+			c.PasswordEnv = fields[2]
+		default:
+			return nil, fmt.Errorf("invalid password source %q for module %s: expected file or env", fields[1], module)
+		}
+		creds[module] = c
+	}
+	return creds, nil
+}
+
+// Address families a module can be checked over.
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// parseIPFamilies parses a comma-separated list such as "ipv4,ipv6".
+func parseIPFamilies(spec string) ([]string, error) {
+	var families []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		if f != familyIPv4 && f != familyIPv6 {
+			return nil, fmt.Errorf("unknown address family %q: expected %s or %s", f, familyIPv4, familyIPv6)
+		}
+		seen[f] = true
+		families = append(families, f)
+	}
+	return families, nil
+}
+
+// parseCanaryFiles parses a CANARY_FILES specification of the form
+// "module=path[:size[:sha256]],...". Size and checksum are optional; when the
+// size is omitted it is compared against the size reported by the daemon.
+func parseCanaryFiles(spec string) (map[string]canaryConfig, error) {
+	files := make(map[string]canaryConfig)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		module, rest, ok := strings.Cut(entry, "=")
+		if !ok || !isValidModulePath(module) {
+			return nil, fmt.Errorf("invalid entry %q: expected module=path[:size[:sha256]]", entry)
+		}
+		fields := strings.Split(rest, ":")
+		if len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected module=path[:size[:sha256]]", entry)
+		}
+		cfg := canaryConfig{Path: strings.TrimPrefix(fields[0], "/"), Size: -1}
+		if len(fields) > 1 && fields[1] != "" {
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("invalid size %q for module %s", fields[1], module)
+			}
+			cfg.Size = size
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			sum := strings.ToLower(fields[2])
+			if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+				return nil, fmt.Errorf("invalid sha256 %q for module %s", fields[2], module)
+			}
+			cfg.SHA256 = sum
+		}
+		files[module] = cfg
+	}
+	return files, nil
+}
+
+
+// --- Data Structures ---
+type CheckResult struct {
+IsUp          bool      `json:"is_up"`
+Message       string    `json:"message,omitempty"`
+Error         string    `json:"error,omitempty"`
+HTTPStatus    int       `json:"http_status"`
+RsyncExitCode int       `json:"rsync_exit_code,omitempty"`
+RsyncOutput   string    `json:"rsync_output,omitempty"`
+Category      string    `json:"category,omitempty"`
+Severity      string    `json:"severity,omitempty"`
+Canary        *CanaryResult `json:"canary,omitempty"`
+FailedLayer   string    `json:"failed_layer,omitempty"`
+Diagnostics   []DiagnosticStep `json:"diagnostics,omitempty"`
+Timestamp     time.Time `json:"timestamp"`
+DurationMs    int64     `json:"duration_ms"` // of the rsync listing, without canary or diagnostics
+}
+
+// Layers of the diagnostic ladder, from the bottom up. The first one that
+// fails is recorded in CheckResult.FailedLayer.
+const (
+	layerDNS      = "dns"
+	layerTCP      = "tcp"
+	layerTLS      = "tls"
+	layerGreeting = "greeting"
+	layerAuth     = "auth"
+	layerModule   = "module"
+	layerContent  = "content"
+)
+
+// DiagnosticStep is the outcome of one layer of the diagnostic ladder.
+type DiagnosticStep struct {
+	Layer      string `json:"layer"`
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// categoryContentError marks a module that lists fine but fails to serve the
+// configured canary file.
+const categoryContentError = "content_error"
+
+// categoryAuthFailed marks a module that rejected our credentials, as opposed
+// to one we could not reach at all.
+const categoryAuthFailed = "auth_failed"
+
+// SSH transport failures, reported separately from daemon failures.
+const (
+	categorySSHHostKey    = "ssh_host_key_mismatch"
+	categorySSHAuthDenied = "ssh_auth_denied"
+)
+
+// categoryUnknown is used when no entry of failureCategories matches.
+const categoryUnknown = "unknown"
+
+// canaryConfig is the file a module must be able to serve and, optionally,
+// its expected size and SHA-256. A Size of -1 means "ask the daemon".
+type canaryConfig struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// CanaryResult records the outcome of transferring a module's canary file.
+type CanaryResult struct {
+	File        string  `json:"file"`
+	Bytes       int64   `json:"bytes"`
+	SHA256      string  `json:"sha256,omitempty"`
+	DurationMs  int64   `json:"duration_ms"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// ThroughputResult is one measurement of the benchmark file transfer.
+type ThroughputResult struct {
+	Timestamp   time.Time `json:"timestamp"`
+	File        string    `json:"file"`
+	Bytes       int64     `json:"bytes"`
+	DurationMs  int64     `json:"duration_ms"`
+	BytesPerSec float64   `json:"bytes_per_sec"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ThroughputProber periodically transfers the benchmark file and keeps the
+// last historyRetention of measurements, like StatusChecker does for module checks.
+type ThroughputProber struct {
+	mu         sync.RWMutex
+	src        string
+	results    []ThroughputResult
+	maxResults int
+}
+
+// ModuleInfo is a module as listed by the daemon: its name and the comment
+// configured for it, e.g. "Debian GNU/Linux archive".
+type ModuleInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// commandsCtx is cancelled by abortCommands when shutdown gives up waiting
+// for checks in progress, killing every process started with runCommand.
+var commandsCtx, abortCommands = context.WithCancel(context.Background())
+
+// runCommand runs cmd and returns its combined output, like CombinedOutput,
+// but kills it once abortCommands is called.
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// An ssh child may keep the output pipe open after rsync is killed.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-commandsCtx.Done():
+		cmd.Process.Kill()
+		<-done
+		return out.Bytes(), fmt.Errorf("command aborted: %w", commandsCtx.Err())
+	}
+}
+
+// rsyncFunc builds an rsync invocation with the given arguments.
+type rsyncFunc func(args ...string) *exec.Cmd
+
+// plainRsync runs rsync with no per-checker options. rsyncs:// URLs are
+// handed to rsync-ssl as rsync:// URLs, with the TLS port and CA bundle
+// passed in its environment.
+func plainRsync(args ...string) *exec.Cmd {
+	args = append([]string(nil), args...)
+	tlsPort := ""
+	for i, a := range args {
+		if !strings.HasPrefix(a, "rsyncs://") {
+			continue
+		}
+		u, err := url.Parse(a)
+		if err != nil {
+			continue
+		}
+		tlsPort = u.Port()
+		if tlsPort == "" {
+			tlsPort = defaultTLSPort
+		}
+		u.Scheme = "rsync"
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
+		}
+		args[i] = u.String()
+	}
+	if tlsPort == "" {
+		return execCommand("rsync", args...)
+	}
+
+	cmd := execCommand(rsyncSSLCommand, args...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "RSYNC_SSL_PORT="+tlsPort)
+	if tlsCAFile != "" {
+		cmd.Env = append(cmd.Env, "RSYNC_SSL_CA_CERT="+tlsCAFile)
+	}
+	return cmd
+}
+
+type StatusChecker struct {
+	mu         sync.RWMutex
+	moduleName string
+	path       string
+	family     string // "" checks over whatever address rsync picks
+	sshTarget  string // "user@host:/path" when checked over ssh instead of the daemon
+	canary     *canaryConfig
+	creds      *credentials
+	job        *scheduledJob
+	results    []CheckResult
+	maxResults int
+}
+
+// --- Failure Classification ---
+
+// Severities of a failure category.
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+)
+
+// FailureCategory is a named class of rsync failure, recognised by the
+// @ERROR message the daemon sends or by rsync's exit code.
+type FailureCategory struct {
+	Name        string   `json:"name"`
+	Severity    string   `json:"severity"`
+	HTTPStatus  int      `json:"http_status"`
+	RetryAfter  int      `json:"retry_after_s,omitempty"`
+	Messages    []string `json:"messages,omitempty"`
+	ExitCodes   []int    `json:"exit_codes,omitempty"`
+	Description string   `json:"description"`
+}
+
+// failureCategories is checked in order: messages are matched first, since
+// the daemon's @ERROR is more specific than the exit code (most of them exit
+// with 5), then exit codes. A message matches the start of a line printed by
+// rsync or ssh; see messageLines.
+var failureCategories = []FailureCategory{
+	{Name: "unknown_module", Severity: severityCritical, HTTPStatus: http.StatusNotFound,
+		Messages: []string{"@ERROR: Unknown module"}, Description: "The daemon does not export this module."},
+	{Name: "max_connections", Severity: severityWarning, HTTPStatus: http.StatusServiceUnavailable, RetryAfter: 60,
+		Messages: []string{"@ERROR: max connections"}, Description: "The daemon reached its connection limit; try again later."},
+	{Name: "access_denied", Severity: severityCritical, HTTPStatus: http.StatusForbidden,
+		Messages: []string{"@ERROR: access denied"}, Description: "The daemon refused this host (hosts allow/deny)."},
+	{Name: categoryAuthFailed, Severity: severityCritical, HTTPStatus: http.StatusUnauthorized,
+		Messages: []string{"@ERROR: auth failed", "ERROR: password file must not be other-accessible"}, Description: "The daemon rejected the configured credentials, or the password file is unusable."},
+	{Name: categorySSHHostKey, Severity: severityCritical, HTTPStatus: http.StatusBadGateway,
+		Messages: []string{"Host key verification failed", "WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED"}, Description: "The ssh host key does not match the known_hosts file."},
+	{Name: categorySSHAuthDenied, Severity: severityCritical, HTTPStatus: http.StatusUnauthorized,
+		Messages: []string{"Permission denied (publickey", "Permission denied, please try again"}, Description: "The ssh server rejected the configured identity."},
+	{Name: "ssh_connection_failed", Severity: severityCritical, HTTPStatus: http.StatusServiceUnavailable,
+		Messages: []string{"ssh: connect to host", "ssh: Could not resolve hostname"}, Description: "ssh could not connect to the host: connection refused, unreachable or unresolvable."},
+	{Name: "chroot_failed", Severity: severityCritical, HTTPStatus: http.StatusInternalServerError,
+		Messages: []string{"@ERROR: chroot failed", "@ERROR: chdir failed"}, Description: "The daemon could not enter the module path."},
+	{Name: categoryContentError, Severity: severityCritical, HTTPStatus: http.StatusInternalServerError,
+		Description: "The module lists fine but its canary file could not be transferred or verified."},
+	{Name: "syntax_error", Severity: severityCritical, HTTPStatus: http.StatusInternalServerError,
+		ExitCodes: []int{1}, Description: "rsync was invoked with invalid options."},
+	{Name: "protocol_incompatible", Severity: severityCritical, HTTPStatus: http.StatusBadGateway,
+		ExitCodes: []int{2}, Description: "Client and daemon protocol versions are incompatible."},
+	{Name: "protocol_error", Severity: severityCritical, HTTPStatus: http.StatusBadGateway,
+		ExitCodes: []int{5}, Description: "Error starting the client-server protocol."},
+	{Name: "socket_io", Severity: severityCritical, HTTPStatus: http.StatusServiceUnavailable,
+		ExitCodes: []int{10}, Description: "Error in socket I/O, e.g. connection refused or unreachable."},
+	{Name: "file_io", Severity: severityCritical, HTTPStatus: http.StatusInternalServerError,
+		ExitCodes: []int{11}, Description: "Error in file I/O."},
+	{Name: "stream_error", Severity: severityCritical, HTTPStatus: http.StatusBadGateway,
+		ExitCodes: []int{12}, Description: "Error in the rsync protocol data stream, usually a dropped connection."},
+	{Name: "partial_transfer", Severity: severityWarning, HTTPStatus: http.StatusInternalServerError,
+		ExitCodes: []int{23}, Description: "Some files or attributes were not transferred."},
+	{Name: "vanished_files", Severity: severityWarning, HTTPStatus: http.StatusInternalServerError,
+		ExitCodes: []int{24}, Description: "Some source files vanished during the transfer."},
+	{Name: "ssh_error", Severity: severityCritical, HTTPStatus: http.StatusBadGateway,
+		ExitCodes: []int{255}, Description: "The ssh transport failed for another reason."},
+	{Name: "timeout", Severity: severityCritical, HTTPStatus: http.StatusGatewayTimeout,
+		ExitCodes: []int{30}, Description: "Timeout in data send/receive."},
+	{Name: "connect_timeout", Severity: severityCritical, HTTPStatus: http.StatusGatewayTimeout,
+		ExitCodes: []int{35}, Description: "Timeout waiting for the daemon connection."},
+	{Name: categoryUnknown, Severity: severityCritical, HTTPStatus: http.StatusInternalServerError,
+		Description: "Any other rsync failure."},
+}
+
+// sshUserPrefix is how ssh starts some of its messages, as in
+// "mirror@host: Permission denied (publickey).".
+var sshUserPrefix = regexp.MustCompile(`^[^\s@:]+@[^\s:]+: `)
+
+// messageLines returns the lines of rsync's output in lower case, without
+// the framing ssh puts around its messages, for matching the start of each
+// against the messages of failureCategories. Matching whole lines from their
+// start keeps free text, such as a daemon MOTD mentioning "max connections",
+// from deciding the category.
+func messageLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "@ERROR") {
+			// ssh frames host key warnings in "@": "@    WARNING: ...     @".
+			line = strings.TrimSpace(strings.Trim(line, "@"))
+		}
+		line = sshUserPrefix.ReplaceAllString(line, "")
+		lines = append(lines, strings.ToLower(line))
+	}
+	return lines
+}
+
+// classifyFailure finds the category of a failed rsync run.
+func classifyFailure(exitCode int, output string) FailureCategory {
+	lines := messageLines(output)
+	for _, c := range failureCategories {
+		for _, msg := range c.Messages {
+			for _, line := range lines {
+				if strings.HasPrefix(line, strings.ToLower(msg)) {
+					return c
+				}
+			}
+		}
+	}
+	for _, c := range failureCategories {
+		for _, code := range c.ExitCodes {
+			if code == exitCode {
+				return c
+			}
+		}
+	}
+	category, _ := categoryByName(categoryUnknown)
+	return category
+}
+
+// categoryByName looks a category up in failureCategories.
+func categoryByName(name string) (FailureCategory, bool) {
+	for _, c := range failureCategories {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return FailureCategory{}, false
+}
+
+// --- Scheduling ---
+
+// clock abstracts time so the scheduler can run under a fake clock in tests.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Scheduler runs every periodic job (module checks, probes, rediscovery) from
+// a single loop. The first run of each job is placed at a random point of
+// the startup window, and at most maxPerServer jobs talk to the same server
+// at the same time; the others wait for a free slot.
+type Scheduler struct {
+	mu           sync.Mutex
+	clock        clock
+	rand         *rand.Rand
+	jitter       float64
+	maxPerServer int
+	slots        map[string]chan struct{}
+	jobs         []*scheduledJob
+	wake         chan struct{}
+	lastTick     time.Time // heartbeat of the Run loop, for /healthz
+	stopped      bool
+	done         chan struct{}  // closed by Stop
+	inFlight     sync.WaitGroup // jobs started and not yet finished
+}
+
+// scheduledJob is one periodic job of a Scheduler.
+type scheduledJob struct {
+	sched    *Scheduler
+	name     string
+	server   string
+	interval time.Duration
+	next     time.Time
+	run      func()
+	running  bool
+	inFlight sync.WaitGroup
+}
+
+func NewScheduler(clk clock, maxPerServer int, jitter float64, seed int64) *Scheduler {
+	return &Scheduler{
+		clock:        clk,
+		rand:         rand.New(rand.NewSource(seed)),
+		jitter:       jitter,
+		maxPerServer: maxPerServer,
+		slots:        make(map[string]chan struct{}),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+}
+
+// schedulerStartupWindow bounds how long the first run of a job is delayed,
+// so that every module is checked soon after it is added however long its
+// interval is.
+const schedulerStartupWindow = 30 * time.Second
+
+// Add schedules run every interval against server. The first run comes
+// within jitter times the interval, but no later than schedulerStartupWindow.
+func (s *Scheduler) Add(name, server string, interval time.Duration, run func()) *scheduledJob {
+	s.mu.Lock()
+	window := time.Duration(s.jitter * float64(interval))
+	if window > schedulerStartupWindow {
+		window = schedulerStartupWindow
+	}
+	offset := time.Duration(s.rand.Float64() * float64(window))
+	j := &scheduledJob{sched: s, name: name, server: server, interval: interval, next: s.clock.Now().Add(offset), run: run}
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	// Let the loop reconsider when it next needs to wake up.
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return j
+}
+
+// Run dispatches jobs until Stop is called.
+func (s *Scheduler) Run() {
+	for {
+		wait := s.tick()
+		select {
+		case <-s.clock.After(wait):
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stop makes the scheduler stop starting jobs. Jobs already running are not
+// interrupted; use Wait to wait for them.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+}
+
+// Wait blocks until every job started before Stop has finished, or ctx is done.
+func (s *Scheduler) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tick starts every job that is due and returns how long until the next one.
+// A job still running from its previous turn is skipped rather than queued.
+func (s *Scheduler) tick() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return time.Minute
+	}
+	now := s.clock.Now()
+	s.lastTick = now
+	wait := time.Duration(-1)
+	for _, j := range s.jobs {
+		if !j.next.After(now) {
+			for !j.next.After(now) {
+				j.next = j.next.Add(j.interval)
+			}
+			if !j.running {
+				j.running = true
+				j.inFlight.Add(1)
+				s.inFlight.Add(1)
+				go s.execute(j, s.slot(j.server))
+			}
+		}
+		if until := j.next.Sub(now); wait < 0 || until < wait {
+			wait = until
+		}
+	}
+	// Wake up at least every schedulerHeartbeat so /healthz can tell a
+	// stuck loop from one that simply has nothing to do.
+	if wait < 0 || wait > schedulerHeartbeat {
+		wait = schedulerHeartbeat
+	}
+	return wait
+}
+
+// schedulerHeartbeat is the longest the scheduler loop sleeps between ticks.
+const schedulerHeartbeat = time.Minute
+
+// health reports whether the Run loop is alive: it must have ticked within
+// a few heartbeats and not have been stopped.
+func (s *Scheduler) health() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	age := s.clock.Now().Sub(s.lastTick)
+	h := map[string]interface{}{
+		"ok":   false,
+		"jobs": len(s.jobs),
+	}
+	switch {
+	case s.stopped:
+		h["detail"] = "scheduler stopped"
+	case s.lastTick.IsZero():
+		h["detail"] = "scheduler loop has not started"
+	case age > 3*schedulerHeartbeat:
+		h["detail"] = fmt.Sprintf("scheduler loop last ticked %v ago", age.Round(time.Second))
+	default:
+		h["ok"] = true
+	}
+	if !s.lastTick.IsZero() {
+		h["last_tick"] = s.lastTick
+	}
+	return h
+}
+
+// slot returns the semaphore limiting concurrent jobs for server. The caller
+// holds s.mu.
+func (s *Scheduler) slot(server string) chan struct{} {
+	sem, ok := s.slots[server]
+	if !ok {
+		sem = make(chan struct{}, s.maxPerServer)
+		s.slots[server] = sem
+	}
+	return sem
+}
+
+// withSlot runs fn once it holds one of server's slots, so work started
+// outside the scheduled jobs still counts against maxPerServer.
+func (s *Scheduler) withSlot(server string, fn func()) {
+	s.mu.Lock()
+	sem := s.slot(server)
+	s.mu.Unlock()
+	sem <- struct{}{}
+	defer func() { <-sem }()
+	fn()
+}
+
+func (s *Scheduler) execute(j *scheduledJob, sem chan struct{}) {
+	defer s.inFlight.Done()
+	defer j.inFlight.Done()
+	sem <- struct{}{}
+	defer func() {
+		<-sem
+		s.mu.Lock()
+		j.running = false
+		s.mu.Unlock()
+	}()
+
+	// Jobs still waiting for a slot when the scheduler stopped are dropped.
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+	if !stopped {
+		j.run()
+	}
+}
+
+// Stop removes the job from its scheduler and waits for a run in progress
+// to finish.
+func (j *scheduledJob) Stop() {
+	s := j.sched
+	s.mu.Lock()
+	for i, other := range s.jobs {
+		if other == j {
+			s.jobs = append(s.jobs[:i:i], s.jobs[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	j.inFlight.Wait()
+}
+
+// serverOf returns the host an rsync URL or "user@host:/path" target points
+// at, which is what the per-server concurrency limit is keyed on.
+func serverOf(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return sshHost(target)
+}
+
+// --- Core Functions ---
+// moduleListingLine matches a line of the daemon's module listing: the name,
+// padded with spaces, a tab and the comment. The MOTD printed before the
+// listing has no such tab. (--no-motd cannot be used instead: the rsync
+// client suppresses the listing along with the MOTD.)
+var moduleListingLine = regexp.MustCompile(`^(\S+) *\t(.*)$`)
+
+func discoverModules(baseURL string) ([]ModuleInfo, error) {
+	cmd := plainRsync(baseURL)
+	out, err := runCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("rsync command failed: %w\nOutput: %s", err, string(out))
+	}
+	var modules []ModuleInfo
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		m := moduleListingLine.FindStringSubmatch(strings.TrimRight(scanner.Text(), "\r"))
+		if m == nil {
+			continue
+		}
+		modules = append(modules, ModuleInfo{
+			Name:        m[1],
+			Description: strings.TrimSpace(m[2]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rsync output: %w", err)
+	}
+	return modules, nil
+}
+
+func NewStatusChecker(moduleName string) *StatusChecker {
+	maxResults := int(historyRetention/pollingInterval)
+	if maxResults < 1 {
+		maxResults = 1
+	}
+	sc := &StatusChecker{
+		moduleName: moduleName,
+		path:       fmt.Sprintf("/%s/", moduleName),
+		results:    make([]CheckResult, 0, maxResults),
+		maxResults: maxResults,
+	}
+	if cfg, ok := canaryFiles[moduleName]; ok {
+		sc.canary = &cfg
+	}
+	if c, ok := moduleCredentials[moduleName]; ok {
+		sc.creds = &c
+	}
+	return sc
+}
+
+// StartPolling registers the checker with the scheduler.
+func (sc *StatusChecker) StartPolling(s *Scheduler) {
+	sc.job = s.Add(sc.label(), sc.server(), pollingInterval, sc.performCheck)
+}
+
+// Stop stops scheduling checks of this module and waits for a check in
+// progress to finish.
+func (sc *StatusChecker) Stop() {
+	if sc.job != nil {
+		sc.job.Stop()
+		sc.job = nil
+	}
+}
+
+// server is the host this checker talks to.
+func (sc *StatusChecker) server() string {
+	if sc.sshTarget != "" {
+		return sshHost(sc.sshTarget)
+	}
+	return serverOf(rsyncURL)
+}
+
+func (sc *StatusChecker) performCheck() {
+	moduleURL := sc.moduleURL()
+	cmd := sc.rsyncCommand(moduleURL)
+	started := time.Now()
+	out, err := runCommand(cmd)
+	elapsed := time.Since(started)
+	// A check killed at shutdown says nothing about the module, so it is
+	// not recorded (here, or after the canary below).
+	if commandsCtx.Err() != nil {
+		return
+	}
+
+	newResult := CheckResult{Timestamp: time.Now(), DurationMs: elapsed.Milliseconds()}
+	outputStr := string(out)
+
+   if err == nil {
+		   newResult.IsUp = true
+		   newResult.Message = "Operational"
+		   newResult.Error = ""
+		   newResult.HTTPStatus = http.StatusOK
+		   newResult.RsyncExitCode = 0
+
+		   if sc.canary != nil {
+			   newResult.Canary = runCanary(sc.rsyncCommand, moduleURL, *sc.canary)
+			   if newResult.Canary.Error != "" {
+				   newResult.IsUp = false
+				   newResult.Message = ""
+				   newResult.Error = "Canary check failed: " + newResult.Canary.Error
+				   category, _ := categoryByName(categoryContentError)
+				   newResult.Category = category.Name
+				   newResult.Severity = category.Severity
+				   newResult.FailedLayer = layerContent
+				   newResult.HTTPStatus = category.HTTPStatus
+			   }
+		   }
+   } else {
+		   newResult.IsUp = false
+		   newResult.Message = ""
+		   newResult.RsyncOutput = strings.TrimSpace(outputStr)
+
+		   var exiterr *exec.ExitError
+		   if errors.As(err, &exiterr) {
+				   if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+						   newResult.RsyncExitCode = status.ExitStatus()
+				   }
+		   }
+
+		   // Extrai a primeira linha do erro do rsync para o campo Error
+		   firstLine := firstOutputLine(outputStr)
+
+		   category := classifyFailure(newResult.RsyncExitCode, outputStr)
+		   newResult.Category = category.Name
+		   newResult.Severity = category.Severity
+		   newResult.HTTPStatus = category.HTTPStatus
+		   newResult.Error = firstLine
+
+		   if sc.sshTarget != "" {
+				   newResult.Diagnostics, newResult.FailedLayer = diagnoseSSH(sc.sshTarget, firstLine, sc.family)
+		   } else {
+				   newResult.Diagnostics, newResult.FailedLayer = diagnose(moduleURL, firstLine, sc.family)
+		   }
+		   // The server is reachable and answered, but rejected our login.
+		   isAuth := category.Name == categoryAuthFailed || category.Name == categorySSHAuthDenied || category.Name == categorySSHHostKey
+		   if newResult.FailedLayer == layerModule && isAuth {
+				   newResult.FailedLayer = layerAuth
+				   newResult.Diagnostics[len(newResult.Diagnostics)-1].Layer = layerAuth
+		   }
+   }
+
+	if commandsCtx.Err() != nil {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if n := len(sc.results); n > 0 && sc.results[n-1].IsUp != newResult.IsUp {
+		if newResult.IsUp {
+			log.Printf("ALERT: %s recovered", sc.label())
+		} else {
+			log.Printf("ALERT: %s is down: %s", sc.label(), newResult.Error)
+		}
+	}
+	sc.results = append(sc.results, newResult)
+	if len(sc.results) > sc.maxResults {
+		sc.results = sc.results[1:]
+	}
+	events.publish(Event{Type: eventCheck, Module: sc.moduleName, Family: sc.family, Result: newResult})
+}
+
+// rsyncCommand builds the rsync invocation for this checker, putting the
+// options it needs, such as the address family, in front of args.
+func (sc *StatusChecker) rsyncCommand(args ...string) *exec.Cmd {
+	var opts []string
+	switch sc.family {
+	case familyIPv4:
+		opts = append(opts, "-4")
+	case familyIPv6:
+		opts = append(opts, "-6")
+	}
+	if sc.creds != nil && sc.creds.PasswordFile != "" {
+		opts = append(opts, "--password-file="+sc.creds.PasswordFile)
+	}
+	if sc.sshTarget != "" {
+		opts = append(opts, "--rsh="+sshRemoteShell())
+	}
+	cmd := plainRsync(append(opts, args...)...)
+	if sc.creds != nil && sc.creds.PasswordEnv != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "RSYNC_PASSWORD="+os.Getenv(sc.creds.PasswordEnv))
+	}
+	return cmd
+}
+
+// moduleURL is the rsync URL checked for this module, including the user
+// name for authenticated modules.
+func (sc *StatusChecker) moduleURL() string {
+	if sc.sshTarget != "" {
+		return sc.sshTarget
+	}
+	base := rsyncURL
+	if sc.creds != nil {
+		if u, err := url.Parse(rsyncURL); err == nil {
+			u.User = url.User(sc.creds.User)
+			base = u.String()
+		}
+	}
+	return base + sc.moduleName
+}
+
+// sshRemoteShell is the ssh command line rsync uses for ssh targets. It never
+// prompts: host keys must already be known and the identity needs no passphrase.
+func sshRemoteShell() string {
+	args := []string{sshCommand, "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes", "-p", sshPort}
+	if sshIdentityFile != "" {
+		args = append(args, "-i", sshIdentityFile)
+	}
+	if sshKnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+sshKnownHostsFile)
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = rshQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// rshQuote quotes an argument of a --rsh command line, which rsync splits on
+// spaces itself, so that paths with spaces stay one argument. Inside single
+// quotes, rsync reads a doubled quote as a literal one.
+func rshQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+}
+
+// label names the checker in logs, e.g. "module debian (ipv6)".
+func (sc *StatusChecker) label() string {
+	if sc.family != "" {
+		return fmt.Sprintf("module %s (%s)", sc.moduleName, sc.family)
+	}
+	return "module " + sc.moduleName
+}
+
+// latest returns the most recent check result, if any.
+func (sc *StatusChecker) latest() (CheckResult, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if len(sc.results) == 0 {
+		return CheckResult{}, false
+	}
+	return sc.results[len(sc.results)-1], true
+}
+
+// diagnose walks the connectivity ladder for a failed check of moduleURL:
+// DNS resolution, TCP connect, the daemon's @RSYNCD greeting and finally the
+// module itself, whose failure is moduleErr. A non-empty family restricts the
+// ladder to that address family. It stops at the first layer that fails and
+// returns the steps taken along with the name of that layer.
+func diagnose(moduleURL, moduleErr, family string) ([]DiagnosticStep, string) {
+	host, port, tlsConfig, err := daemonEndpoint(moduleURL)
+	if err != nil {
+		layer := layerDNS
+		if host != "" {
+			layer = layerTLS
+		}
+		return []DiagnosticStep{{Layer: layer, Detail: err.Error()}}, layer
+	}
+	return diagnoseEndpoint(host, port, tlsConfig, "@RSYNCD: ", moduleErr, family)
+}
+
+// daemonEndpoint extracts the host and port of the daemon behind an rsync://
+// or rsyncs:// URL, along with the TLS configuration for the latter. When the
+// URL is valid but TLS cannot be configured, host is still returned.
+func daemonEndpoint(rawURL string) (string, string, *tls.Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "", "", nil, fmt.Errorf("invalid rsync URL %q", rawURL)
+	}
+	port := u.Port()
+	var tlsConfig *tls.Config
+	if u.Scheme == "rsyncs" {
+		if tlsConfig, err = tlsClientConfig(u.Hostname()); err != nil {
+			return u.Hostname(), "", nil, err
+		}
+		if port == "" {
+			port = defaultTLSPort
+		}
+	}
+	if port == "" {
+		port = "873"
+	}
+	return u.Hostname(), port, tlsConfig, nil
+}
+
+// diagnoseSSH is diagnose for a "user@host:/path" target reached over ssh,
+// whose server greets clients with "SSH-<version>".
+func diagnoseSSH(target, moduleErr, family string) ([]DiagnosticStep, string) {
+	host := sshHost(target)
+	if host == "" {
+		return []DiagnosticStep{{Layer: layerDNS, Detail: fmt.Sprintf("invalid ssh target %q", target)}}, layerDNS
+	}
+	return diagnoseEndpoint(host, sshPort, nil, "SSH-", moduleErr, family)
+}
+
+// sshHost extracts the host of a "user@host:/path" target.
+func sshHost(target string) string {
+	host, _, _ := strings.Cut(target, ":")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return strings.Trim(host, "[]")
+}
+
+// diagnoseEndpoint runs the ladder against host:port, expecting the server to
+// greet with a line starting with greetingPrefix. A non-nil tlsConfig adds a
+// TLS handshake between the TCP connect and the greeting.
+func diagnoseEndpoint(host, port string, tlsConfig *tls.Config, greetingPrefix, moduleErr, family string) ([]DiagnosticStep, string) {
+	var steps []DiagnosticStep
+
+	// DNS: literal addresses need no resolution.
+	addr := host
+	if net.ParseIP(host) == nil {
+		start := time.Now()
+		addrs, err := lookupHost(host)
+		step := DiagnosticStep{Layer: layerDNS, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil || len(addrs) == 0 {
+			step.Detail = fmt.Sprintf("could not resolve %s: %v", host, err)
+			return append(steps, step), layerDNS
+		}
+		addrs = filterFamily(addrs, family)
+		if len(addrs) == 0 {
+			step.Detail = fmt.Sprintf("%s has no %s address", host, family)
+			return append(steps, step), layerDNS
+		}
+		addr = addrs[0]
+		step.OK = true
+		step.Detail = strings.Join(addrs, ", ")
+		steps = append(steps, step)
+	}
+
+	// TCP connect to the daemon port.
+	network := "tcp"
+	switch family {
+	case familyIPv4:
+		network = "tcp4"
+	case familyIPv6:
+		network = "tcp6"
+	}
+	start := time.Now()
+	conn, err := dialTimeout(network, net.JoinHostPort(addr, port), diagnosticTimeout)
+	step := DiagnosticStep{Layer: layerTCP, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		step.Detail = err.Error()
+		return append(steps, step), layerTCP
+	}
+	defer conn.Close()
+	step.OK = true
+	step.Detail = conn.RemoteAddr().String()
+	steps = append(steps, step)
+
+	if tlsConfig != nil {
+		start = time.Now()
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(diagnosticTimeout))
+		err := tlsConn.Handshake()
+		step = DiagnosticStep{Layer: layerTLS, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			step.Detail = err.Error()
+			return append(steps, step), layerTLS
+		}
+		step.OK = true
+		step.Detail = tls.VersionName(tlsConn.ConnectionState().Version)
+		steps = append(steps, step)
+		conn = tlsConn
+	}
+
+	// The daemon greets every client with "@RSYNCD: <protocol version>".
+	start = time.Now()
+	greeting, err := readGreeting(conn, bufio.NewReader(conn), greetingPrefix)
+	step = DiagnosticStep{Layer: layerGreeting, DurationMs: time.Since(start).Milliseconds(), Detail: greeting}
+	if err != nil {
+		step.Detail = err.Error()
+		return append(steps, step), layerGreeting
+	}
+	step.OK = true
+	steps = append(steps, step)
+
+	// Everything below the module works, so the module itself is the problem.
+	steps = append(steps, DiagnosticStep{Layer: layerModule, Detail: moduleErr})
+	return steps, layerModule
+}
+
+// filterFamily keeps only the addresses of the given family; an empty family
+// keeps them all.
+func filterFamily(addrs []string, family string) []string {
+	if family == "" {
+		return addrs
+	}
+	var kept []string
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if isV4 := ip.To4() != nil; isV4 == (family == familyIPv4) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// readGreeting reads from r the line a server sends as soon as a client
+// connects to conn, such as "@RSYNCD: <version>" for an rsync daemon or
+// "SSH-2.0-..." for sshd.
+func readGreeting(conn net.Conn, r *bufio.Reader, prefix string) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(diagnosticTimeout))
+	line, err := r.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no greeting from server: %w", err)
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("unexpected greeting %q", line)
+	}
+	return line, nil
+}
+
+// firstOutputLine returns the first non-empty line of rsync output, which is
+// where rsync puts the reason for a failure.
+func firstOutputLine(output string) string {
+	for _, l := range strings.Split(output, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			return l
+		}
+	}
+	return "Erro desconhecido do rsync"
+}
+
+// transferFile copies a single file from the daemon into a temporary
+// directory and returns its size, SHA-256 and how long the transfer took.
+func transferFile(rsync rsyncFunc, src string) (int64, string, time.Duration, error) {
+	tmpDir, err := os.MkdirTemp("", "rsyncuptime-transfer-")
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	start := time.Now()
+	out, err := runCommand(rsync(src, tmpDir+"/"))
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, "", elapsed, errors.New(firstOutputLine(string(out)))
+	}
+
+	f, err := os.Open(filepath.Join(tmpDir, path.Base(src)))
+	if err != nil {
+		return 0, "", elapsed, fmt.Errorf("transferred file not found: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", elapsed, fmt.Errorf("could not read transferred file: %w", err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), elapsed, nil
+}
+
+// bytesPerSec returns the transfer rate, or 0 for an instantaneous transfer.
+func bytesPerSec(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed.Seconds()
+}
+
+// runCanary transfers the canary file of a module and verifies its size and
+// checksum. Any failure is reported in the Error field of the returned result.
+func runCanary(rsync rsyncFunc, moduleURL string, cfg canaryConfig) *CanaryResult {
+	res := &CanaryResult{File: cfg.Path}
+	src := strings.TrimSuffix(moduleURL, "/") + "/" + cfg.Path
+	n, sum, elapsed, err := transferFile(rsync, src)
+	res.DurationMs = elapsed.Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Bytes = n
+	res.SHA256 = sum
+	res.BytesPerSec = bytesPerSec(n, elapsed)
+
+	expectedSize := cfg.Size
+	if expectedSize < 0 {
+		expectedSize = upstreamFileSize(rsync, src)
+	}
+	if expectedSize >= 0 && expectedSize != n {
+		res.Error = fmt.Sprintf("size mismatch: got %d bytes, expected %d", n, expectedSize)
+		return res
+	}
+	if cfg.SHA256 != "" && cfg.SHA256 != res.SHA256 {
+		res.Error = fmt.Sprintf("sha256 mismatch: got %s, expected %s", res.SHA256, cfg.SHA256)
+	}
+	return res
+}
+
+// upstreamFileSize asks the daemon for the size of a single file, as shown in
+// its listing ("-rw-r--r--  1,234 2024/01/01 00:00:00 name"). It returns -1
+// when the size cannot be determined.
+func upstreamFileSize(rsync rsyncFunc, src string) int64 {
+	out, err := runCommand(rsync(src))
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.ReplaceAll(fields[1], ",", ""), 10, 64)
+		if err == nil {
+			return size
+		}
+	}
+	return -1
+}
+
+// historyEntry is one entry of a /status response: a single check or, when
+// a resolution is requested, a summary of the checks of one time bucket.
+type historyEntry struct {
+	CheckResult          // the bucket's last check, with its longest duration
+	checks      int
+	failures    int
+	lastError   string // of the bucket's last failed check
+}
+
+// summarizeResults keeps the results of the last window, or all of them if
+// window is 0, and with a non-zero resolution merges them into buckets of
+// that length. Buckets are aligned to multiples of the resolution, so that
+// the buckets of different modules cover the same times.
+func summarizeResults(results []CheckResult, now time.Time, window, resolution time.Duration) []historyEntry {
+	var entries []historyEntry
+	for _, res := range results {
+		if window > 0 && now.Sub(res.Timestamp) > window {
+			continue
+		}
+		failures := 0
+		if !res.IsUp {
+			failures = 1
+		}
+		if n := len(entries); resolution > 0 && n > 0 && res.Timestamp.Truncate(resolution).Equal(entries[n-1].Timestamp.Truncate(resolution)) {
+			last := &entries[n-1]
+			longest := max(last.DurationMs, res.DurationMs)
+			last.CheckResult = res
+			last.DurationMs = longest
+			last.checks++
+			last.failures += failures
+			if !res.IsUp {
+				last.lastError = res.Error
+			}
+			continue
+		}
+		entry := historyEntry{CheckResult: res, checks: 1, failures: failures}
+		if !res.IsUp {
+			entry.lastError = res.Error
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// historyQuery parses the window and resolution query parameters of /status,
+// e.g. ?window=7d&resolution=30m.
+func historyQuery(r *http.Request) (window, resolution time.Duration, err error) {
+	if spec := r.URL.Query().Get("window"); spec != "" {
+		if window, err = parseSpan(spec); err != nil || window <= 0 {
+			return 0, 0, fmt.Errorf("window '%s' is not a duration such as 1h, 24h or 7d", spec)
+		}
+	}
+	if spec := r.URL.Query().Get("resolution"); spec != "" {
+		if resolution, err = parseSpan(spec); err != nil || resolution <= 0 {
+			return 0, 0, fmt.Errorf("resolution '%s' is not a duration such as 5m or 1h", spec)
+		}
+	}
+	return window, resolution, nil
+}
+
+func (sc *StatusChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	window, resolution, err := historyQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid query: "+err.Error()+".", r.URL.Path)
+		return
+	}
+
+	sc.mu.RLock()
+	resultsCopy := make([]CheckResult, len(sc.results))
+	copy(resultsCopy, sc.results)
+	sc.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if len(resultsCopy) > 0 {
+		latest := resultsCopy[len(resultsCopy)-1]
+		if category, ok := categoryByName(latest.Category); ok && !latest.IsUp && category.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(category.RetryAfter))
+		}
+		w.WriteHeader(latest.HTTPStatus)
+	}
+
+	// Adiciona o campo 'code' com o valor do RsyncExitCode em erros
+	var resp []map[string]interface{}
+	for _, entry := range summarizeResults(resultsCopy, time.Now(), window, resolution) {
+		res := entry.CheckResult
+		m := make(map[string]interface{})
+		if resolution > 0 {
+			m["checks"] = entry.checks
+			m["failures"] = entry.failures
+			if res.IsUp && entry.failures > 0 {
+				m["last_error"] = entry.lastError
+			}
+		}
+		m["is_up"] = res.IsUp
+		m["success"] = res.IsUp
+		if res.IsUp {
+			m["message"] = res.Message
+		} else {
+			m["error"] = res.Error
+		}
+		m["http_status"] = res.HTTPStatus
+		m["timestamp"] = res.Timestamp
+		m["duration_ms"] = res.DurationMs
+		m["path"] = sc.path
+		if sc.family != "" {
+			m["family"] = sc.family
+		}
+		if sc.sshTarget != "" {
+			m["transport"] = "ssh"
+		}
+		if res.RsyncOutput != "" {
+			m["rsync_output"] = res.RsyncOutput
+		}
+		if res.Category != "" {
+			m["category"] = res.Category
+		}
+		if res.Severity != "" {
+			m["severity"] = res.Severity
+		}
+		if res.Canary != nil {
+			m["canary"] = res.Canary
+		}
+		if res.FailedLayer != "" {
+			m["failed_layer"] = res.FailedLayer
+		}
+		if len(res.Diagnostics) > 0 {
+			m["diagnostics"] = res.Diagnostics
+		}
+		// Se for erro, coloca o code do rsync
+		if !res.IsUp {
+			m["code"] = res.RsyncExitCode
+			if res.RsyncExitCode != 0 {
+				m["rsync_exit_code"] = res.RsyncExitCode
+			}
+		} else {
+			m["code"] = 0
+		}
+		resp = append(resp, m)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func NewThroughputProber(file string) *ThroughputProber {
+	maxResults := int(historyRetention / benchmarkInterval)
+	if maxResults < 1 {
+		maxResults = 1
+	}
+	return &ThroughputProber{
+		src:        rsyncURL + file,
+		results:    make([]ThroughputResult, 0, maxResults),
+		maxResults: maxResults,
+	}
+}
+
+// restore prepends measurements saved before a restart to the history.
+func (tp *ThroughputProber) restore(saved []ThroughputResult) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.results = append(append([]ThroughputResult(nil), saved...), tp.results...)
+	if extra := len(tp.results) - tp.maxResults; extra > 0 {
+		tp.results = tp.results[extra:]
+	}
+}
+
+func (tp *ThroughputProber) StartPolling(s *Scheduler) {
+	s.Add("throughput probe", serverOf(tp.src), benchmarkInterval, tp.performProbe)
+}
+
+func (tp *ThroughputProber) performProbe() {
+	res := ThroughputResult{Timestamp: time.Now(), File: path.Base(tp.src)}
+	n, _, elapsed, err := transferFile(plainRsync, tp.src)
+	res.DurationMs = elapsed.Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		log.Printf("WARN: Throughput probe for %s failed: %v", tp.src, err)
+	} else {
+		res.Bytes = n
+		res.BytesPerSec = bytesPerSec(n, elapsed)
+	}
+	if commandsCtx.Err() != nil {
+		return
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.results = append(tp.results, res)
+	if len(tp.results) > tp.maxResults {
+		tp.results = tp.results[1:]
+	}
+	events.publish(Event{Type: eventThroughput, Result: res})
+}
+
+func (tp *ThroughputProber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tp.mu.RLock()
+	resultsCopy := make([]ThroughputResult, len(tp.results))
+	copy(resultsCopy, tp.results)
+	tp.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultsCopy)
+}
+
+// Event is a result pushed to /events subscribers as soon as it is recorded.
+type Event struct {
+	Type   string      `json:"-"` // the SSE event name
+	Module string      `json:"module,omitempty"`
+	Family string      `json:"family,omitempty"`
+	Result interface{} `json:"result"`
+}
+
+// Types of Event.
+const (
+	eventCheck      = "check"      // a module check, Result is a CheckResult
+	eventThroughput = "throughput" // a throughput probe, Result is a ThroughputResult
+)
+
+// eventBuffer is how many events a subscriber may lag behind before it is
+// dropped. A dropped client reconnects and fetches the full history again.
+const eventBuffer = 64
+
+// eventKeepalive is how often an idle event stream gets a comment line, so
+// proxies keep it open and clients can tell a dead connection from a quiet one.
+const eventKeepalive = 15 * time.Second
+
+// eventHub fans events out to every /events subscriber. Publishing never
+// blocks a check on a slow client.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// events carries every check and probe result to the /events endpoint.
+var events = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]struct{})}
+}
+
+// subscribe returns a channel receiving every event published from now on,
+// and a function to stop receiving them. The channel is closed when the
+// subscriber is dropped or the hub is closed.
+func (h *eventHub) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("WARN: Dropping a slow /events subscriber.")
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every stream, so they don't hold up the HTTP server's shutdown.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// eventsHandler serves /events, a Server-Sent Events stream of check and
+// throughput results as they are recorded. Clients get the history from
+// /status and /throughput and then follow the stream.
+func eventsHandler(h *eventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSONError(w, http.StatusNotImplemented, "Streaming is not supported by this connection.", r.URL.Path)
+			return
+		}
+		ch, unsubscribe := h.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		// Tell nginx not to buffer the stream.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": subscribed\n\n")
+		flusher.Flush()
+
+		keepalive := time.NewTicker(eventKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Printf("WARN: Could not encode %s event: %v", e.Type, err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// DaemonInfo is what the daemon advertises to every client: its protocol
+// version in the greeting and its message of the day.
+type DaemonInfo struct {
+	URL             string    `json:"url"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	MOTD            string    `json:"motd"`
+	CheckedAt       time.Time `json:"checked_at"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// DaemonChange records when the protocol version or MOTD changed.
+type DaemonChange struct {
+	Timestamp       time.Time `json:"timestamp"`
+	ProtocolVersion string    `json:"protocol_version"`
+	MOTD            string    `json:"motd"`
+}
+
+// DaemonMonitor periodically reads the daemon's greeting and MOTD, keeping
+// the latest values and a history of changes.
+type DaemonMonitor struct {
+	mu         sync.RWMutex
+	url        string
+	info       DaemonInfo
+	history    []DaemonChange
+	maxHistory int
+}
+
+func NewDaemonMonitor(rawURL string) *DaemonMonitor {
+	return &DaemonMonitor{url: rawURL, info: DaemonInfo{URL: rawURL}, maxHistory: 100}
+}
+
+func (dm *DaemonMonitor) StartPolling(s *Scheduler) {
+	s.Add("daemon probe", serverOf(dm.url), pollingInterval, dm.performCheck)
+}
+
+func (dm *DaemonMonitor) performCheck() {
+	version, motd, err := probeDaemon(dm.url)
+	now := time.Now()
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.info.CheckedAt = now
+	if err != nil {
+		// Keep the last known values; the module checks report the outage.
+		dm.info.Error = err.Error()
+		return
+	}
+	dm.info.Error = ""
+	dm.info.ProtocolVersion = version
+	dm.info.MOTD = motd
+
+	if n := len(dm.history); n > 0 && dm.history[n-1].ProtocolVersion == version && dm.history[n-1].MOTD == motd {
+		return
+	}
+	if len(dm.history) > 0 {
+		log.Printf("Daemon %s changed: protocol version %s, MOTD %q", dm.url, version, motd)
+	}
+	dm.history = append(dm.history, DaemonChange{Timestamp: now, ProtocolVersion: version, MOTD: motd})
+	if len(dm.history) > dm.maxHistory {
+		dm.history = dm.history[1:]
+	}
+}
+
+// Info returns the latest daemon information.
+func (dm *DaemonMonitor) Info() DaemonInfo {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.info
+}
+
+func (dm *DaemonMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dm.mu.RLock()
+	historyCopy := make([]DaemonChange, len(dm.history))
+	copy(historyCopy, dm.history)
+	info := dm.info
+	dm.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]map[string]interface{}{{
+		"url":              info.URL,
+		"protocol_version": info.ProtocolVersion,
+		"motd":             info.MOTD,
+		"checked_at":       info.CheckedAt,
+		"error":            info.Error,
+		"history":          historyCopy,
+	}})
+}
+
+// probeDaemon speaks just enough of the daemon protocol to learn its version
+// and MOTD: it reads the greeting, answers with its own, and collects what
+// the daemon sends before it starts waiting for a module name.
+func probeDaemon(rawURL string) (string, string, error) {
+	host, port, tlsConfig, err := daemonEndpoint(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	conn, err := dialTimeout("tcp", net.JoinHostPort(host, port), diagnosticTimeout)
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(diagnosticTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return "", "", err
+		}
+		conn = tlsConn
+	}
+
+	r := bufio.NewReader(conn)
+	greeting, err := readGreeting(conn, r, "@RSYNCD: ")
+	if err != nil {
+		return "", "", err
+	}
+	// "@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4": the version, then digests.
+	version := strings.Fields(strings.TrimPrefix(greeting, "@RSYNCD: "))[0]
+
+	conn.SetWriteDeadline(time.Now().Add(diagnosticTimeout))
+	if _, err := fmt.Fprintf(conn, "@RSYNCD: %s\n", version); err != nil {
+		return version, "", err
+	}
+
+	var motd []string
+	for {
+		conn.SetReadDeadline(time.Now().Add(motdIdleTimeout))
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "@ERROR") {
+			return version, "", errors.New(line)
+		}
+		if strings.HasPrefix(line, "@RSYNCD: EXIT") {
+			break
+		}
+		if err != nil {
+			if line != "" {
+				motd = append(motd, line)
+			}
+			break
+		}
+		motd = append(motd, line)
+	}
+	return version, strings.TrimSpace(strings.Join(motd, "\n")), nil
+}
+
+// CertStatus is the result of inspecting the daemon's TLS certificate.
+type CertStatus struct {
+	Host          string    `json:"host"`
+	State         string    `json:"state"`
+	Subject       string    `json:"subject,omitempty"`
+	Issuer        string    `json:"issuer,omitempty"`
+	NotAfter      time.Time `json:"not_after,omitempty"`
+	DaysRemaining float64   `json:"days_remaining"`
+	Error         string    `json:"error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// Certificate states, from good to bad.
+const (
+	certStateOK      = "ok"
+	certStateWarning = "warning"
+	certStateExpired = "expired"
+	certStateError   = "error"
+)
+
+// CertMonitor periodically validates the certificate of an rsyncs:// daemon
+// and keeps the latest result.
+type CertMonitor struct {
+	mu     sync.RWMutex
+	host   string
+	port   string
+	status CertStatus
+}
+
+// NewCertMonitor returns a monitor for the daemon behind an rsyncs:// URL, or
+// nil if the URL is not rsyncs://.
+func NewCertMonitor(rawURL string) *CertMonitor {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "rsyncs" || u.Hostname() == "" {
+		return nil
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultTLSPort
+	}
+	return &CertMonitor{host: u.Hostname(), port: port}
+}
+
+func (cm *CertMonitor) StartPolling(s *Scheduler) {
+	s.Add("certificate check", cm.host, pollingInterval, cm.performCheck)
+}
+
+func (cm *CertMonitor) performCheck() {
+	status := checkCertificate(cm.host, cm.port, time.Now())
+	if status.State != certStateOK {
+		log.Printf("WARN: TLS certificate of %s is %s: %s", cm.host, status.State, certSummary(status))
+	}
+	cm.mu.Lock()
+	cm.status = status
+	cm.mu.Unlock()
+}
+
+// Status returns the latest certificate check.
+func (cm *CertMonitor) Status() CertStatus {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.status
+}
+
+// certSummary describes a certificate status in one line for logs.
+func certSummary(status CertStatus) string {
+	if status.Error != "" {
+		return status.Error
+	}
+	return fmt.Sprintf("certificate expires in %.0f days (%s)", status.DaysRemaining, status.NotAfter.Format(time.RFC3339))
+}
+
+// tlsClientConfig validates serverName against the configured CA bundle, or
+// the system roots when none is configured.
+func tlsClientConfig(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName}
+	if tlsCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(tlsCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", tlsCAFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+// checkCertificate connects to host:port and reports how long the leaf
+// certificate remains valid, and whether its chain and host name validate.
+// The handshake itself does not verify the certificate, or an expired one
+// would fail it and its expiry date would never be seen; the chain is
+// verified afterwards, as of the expiry date at the latest, so that an
+// expired certificate is told apart from an untrusted one.
+func checkCertificate(host, port string, now time.Time) CertStatus {
+	status := CertStatus{Host: host, CheckedAt: now}
+	cfg, err := tlsClientConfig(host)
+	if err != nil {
+		status.State, status.Error = certStateError, err.Error()
+		return status
+	}
+	cfg.InsecureSkipVerify = true
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: diagnosticTimeout}, "tcp", net.JoinHostPort(host, port), cfg)
+	if err != nil {
+		status.State, status.Error = certStateError, err.Error()
+		return status
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	leaf := certs[0]
+	status.Subject = leaf.Subject.String()
+	status.Issuer = leaf.Issuer.String()
+	status.NotAfter = leaf.NotAfter
+	status.DaysRemaining, status.State = certState(leaf.NotAfter, now, certWarningDays)
+
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         cfg.RootCAs, // nil: the system roots
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+	}
+	if leaf.NotAfter.Before(now) {
+		opts.CurrentTime = leaf.NotAfter
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		status.State, status.Error = certStateError, err.Error()
+	}
+	return status
+}
+
+// certState returns the days left until notAfter and the matching state.
+func certState(notAfter, now time.Time, warningDays int) (float64, string) {
+	days := notAfter.Sub(now).Hours() / 24
+	switch {
+	case days <= 0:
+		return days, certStateExpired
+	case days <= float64(warningDays):
+		return days, certStateWarning
+	default:
+		return days, certStateOK
+	}
+}
+
+// writeCertMetrics writes the certificate status in the Prometheus text
+// exposition format.
+func writeCertMetrics(w io.Writer, status CertStatus) {
+	valid := 0
+	if status.State == certStateOK || status.State == certStateWarning {
+		valid = 1
+	}
+	fmt.Fprintln(w, "# HELP rsyncuptime_tls_cert_expiry_days Days until the rsync daemon's TLS certificate expires.")
+	fmt.Fprintln(w, "# TYPE rsyncuptime_tls_cert_expiry_days gauge")
+	if !status.NotAfter.IsZero() {
+		fmt.Fprintf(w, "rsyncuptime_tls_cert_expiry_days{host=%q} %.2f\n", status.Host, status.DaysRemaining)
+	}
+	fmt.Fprintln(w, "# HELP rsyncuptime_tls_cert_valid Whether the rsync daemon's TLS certificate validated and has not expired.")
+	fmt.Fprintln(w, "# TYPE rsyncuptime_tls_cert_valid gauge")
+	fmt.Fprintf(w, "rsyncuptime_tls_cert_valid{host=%q} %d\n", status.Host, valid)
+}
+
+// Monitor owns the monitored modules and their checkers. The module list is
+// rediscovered periodically: new modules get checkers and descriptions are
+// kept up to date. Modules that disappear keep being checked, so their
+// removal shows up as an outage rather than silently.
+type Monitor struct {
+	mu             sync.RWMutex
+	modules        map[string]ModuleInfo
+	ignored        map[string]ModuleInfo // discovered but filtered out
+	filter         *moduleFilter
+	checkers       map[string]*StatusChecker
+	familyCheckers map[string]map[string]*StatusChecker
+	listing        []ModuleInfo // the daemon's last module listing, unfiltered
+	discoveredAt   time.Time
+	discoveryErr   error             // of the last rediscovery, if it failed
+	lastRefresh    time.Time         // of the last POST /refresh, for rate limiting
+	prober         *ThroughputProber // saved and restored with the checkers; nil without BENCHMARK_FILE
+	sched          *Scheduler        // for refreshes on demand; nil in tests
+
+	// start begins polling a new checker. Tests replace it to avoid timers.
+	start func(*StatusChecker)
+}
+
+func NewMonitor(s *Scheduler) *Monitor {
+	m := &Monitor{
+		modules:        make(map[string]ModuleInfo),
+		ignored:        make(map[string]ModuleInfo),
+		filter:         moduleSelection,
+		checkers:       make(map[string]*StatusChecker),
+		familyCheckers: make(map[string]map[string]*StatusChecker),
+		sched:          s,
+		start:          func(sc *StatusChecker) { sc.StartPolling(s) },
+	}
+	// Per-family checkers are independent of the default ones, so an
+	// IPv6-only outage that rsync would route around still shows up.
+	for _, family := range ipFamilies {
+		m.familyCheckers[family] = make(map[string]*StatusChecker)
+	}
+	return m
+}
+
+// applyDiscovery records a fresh module listing, creating checkers for
+// modules seen for the first time and updating descriptions. Modules the
+// filter rejects are only remembered as ignored; explicitly listed modules
+// are monitored even when the listing lacks them.
+func (m *Monitor) applyDiscovery(modules []ModuleInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discoveredAt = time.Now()
+	m.listing = append([]ModuleInfo(nil), modules...)
+	for _, name := range m.filter.explicit {
+		found := false
+		for _, info := range modules {
+			found = found || info.Name == name
+		}
+		if !found {
+			modules = append(modules, ModuleInfo{Name: name})
+		}
+	}
+	for _, info := range modules {
+		if !m.filter.allows(info.Name) {
+			m.ignored[info.Name] = info
+			continue
+		}
+		if old, known := m.modules[info.Name]; known {
+			if old.Description != info.Description {
+				log.Printf("Module %s description changed to %q", info.Name, info.Description)
+			}
+			m.modules[info.Name] = info
+			continue
+		}
+		if _, taken := m.checkers[info.Name]; taken {
+			log.Printf("WARN: Module '%s' has the same name as an SSH target. Ignoring it.", info.Name)
+			continue
+		}
+		m.modules[info.Name] = info
+		m.addChecker(m.checkers, NewStatusChecker(info.Name))
+		for family, fc := range m.familyCheckers {
+			checker := NewStatusChecker(info.Name)
+			checker.family = family
+			m.addChecker(fc, checker)
+		}
+	}
+}
 
-// init runs before main() to load configuration from environment variables.
-func init() {
-   if url := os.Getenv("RSYNC_URL"); url != "" {
-	  rsyncURL = url
-	  log.Printf("Using custom rsync URL from environment: %s", rsyncURL)
-   }
+// addSSHTarget monitors a target reachable only with rsync over ssh.
+func (m *Monitor) addSSHTarget(name, target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.checkers[name]; exists {
+		log.Printf("WARN: SSH target '%s' has the same name as a discovered module. Ignoring it.", name)
+		return
+	}
+	checker := NewStatusChecker(name)
+	checker.sshTarget = target
+	m.addChecker(m.checkers, checker)
+}
 
-   if intervalStr := os.Getenv("POLLING_INTERVAL_SECONDS"); intervalStr != "" {
-	  if intervalSec, err := strconv.Atoi(intervalStr); err == nil && intervalSec > 0 {
-		 pollingInterval = time.Duration(intervalSec) * time.Second
-		 log.Printf("Using custom polling interval from environment: %v", pollingInterval)
-	  } else {
-		 log.Printf("WARN: Invalid POLLING_INTERVAL_SECONDS value '%s'. Using default.", intervalStr)
-	  }
-   }
+// addChecker stores a checker and starts it. The caller holds m.mu.
+func (m *Monitor) addChecker(into map[string]*StatusChecker, checker *StatusChecker) {
+	into[checker.moduleName] = checker
+	if m.start != nil {
+		m.start(checker)
+	}
+}
 
-   if port := os.Getenv("PORT"); port != "" {
-	  serverPort = port
-	  log.Printf("Using custom server port from environment: %s", serverPort)
-   }
+// rediscover fetches the module list again and applies it.
+func (m *Monitor) rediscover() error {
+	modules, err := discoverModules(rsyncURL)
+	m.mu.Lock()
+	m.discoveryErr = err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.applyDiscovery(modules)
+	return nil
 }
 
+// refresh is rediscover on demand. Like the scheduled rediscovery it waits
+// for a free slot of the daemon's server, so it never adds one more
+// connection to those already open.
+func (m *Monitor) refresh() error {
+	if m.sched == nil {
+		return m.rediscover()
+	}
+	var err error
+	m.sched.withSlot(serverOf(rsyncURL), func() { err = m.rediscover() })
+	return err
+}
 
-// --- Data Structures ---
-type CheckResult struct {
-IsUp          bool      `json:"is_up"`
-Message       string    `json:"message,omitempty"`
-Error         string    `json:"error,omitempty"`
-HTTPStatus    int       `json:"http_status"`
-RsyncExitCode int       `json:"rsync_exit_code,omitempty"`
-RsyncOutput   string    `json:"rsync_output,omitempty"`
-Timestamp     time.Time `json:"timestamp"`
+// StartRediscovery refreshes the module list every discoveryInterval.
+func (m *Monitor) StartRediscovery(s *Scheduler) {
+	s.Add("module rediscovery", serverOf(rsyncURL), discoveryInterval, func() {
+		if err := m.rediscover(); err != nil {
+			log.Printf("WARN: Module rediscovery failed: %v", err)
+		}
+	})
 }
 
-type StatusChecker struct {
-	mu         sync.RWMutex
-	moduleName string
-	path       string
-	results    []CheckResult
-	maxResults int
+// moduleListing returns the names of the modules in the daemon's last
+// listing, monitored or not, and when it was fetched.
+func (m *Monitor) moduleListing() ([]string, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.listing))
+	for _, info := range m.listing {
+		names = append(names, info.Name)
+	}
+	return names, m.discoveredAt
 }
 
-// --- Core Functions ---
-func discoverModules(baseURL string) ([]string, error) {
-	cmd := execCommand("rsync", baseURL)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("rsync command failed: %w\nOutput: %s", err, string(out))
+// reserveRefresh claims the right to refresh the module list now. If the last
+// refresh was less than refreshMinInterval ago it returns how long to wait
+// instead.
+func (m *Monitor) reserveRefresh(now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if wait := m.lastRefresh.Add(refreshMinInterval).Sub(now); !m.lastRefresh.IsZero() && wait > 0 {
+		return wait
 	}
-	var modules []string
-	scanner := bufio.NewScanner(strings.NewReader(string(out)))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	m.lastRefresh = now
+	return 0
+}
+
+// refreshHandler serves POST /refresh, the only way for a client to make the
+// server list the daemon's modules on demand. It is rate limited so the
+// endpoint cannot be used to amplify traffic against the daemon.
+func refreshHandler(m *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "Use POST to refresh the module list.", r.URL.Path)
+			return
 		}
-		parts := strings.Fields(line)
-		if len(parts) > 0 {
-			modules = append(modules, parts[0])
+		if wait := m.reserveRefresh(time.Now()); wait > 0 {
+			retryAfter := int((wait + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, http.StatusTooManyRequests, fmt.Sprintf("Module list was refreshed recently. Try again in %ds.", retryAfter), r.URL.Path)
+			return
 		}
+		if err := m.refresh(); err != nil {
+			log.Printf("WARN: Module refresh failed: %v", err)
+			writeJSONError(w, http.StatusBadGateway, "Could not list modules: "+firstOutputLine(err.Error()), r.URL.Path)
+			return
+		}
+		names, discoveredAt := m.moduleListing()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":              r.URL.Path,
+			"success":           true,
+			"rsync_directories": names,
+			"discovered_at":     discoveredAt,
+		})
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading rsync output: %w", err)
-	}
-	return modules, nil
 }
 
-func NewStatusChecker(moduleName string) *StatusChecker {
-	maxResults := int(24*time.Hour/pollingInterval)
-	if maxResults < 1 {
-		maxResults = 1
+// checker returns the checker of a module, optionally for an address family.
+func (m *Monitor) checker(module, family string) (*StatusChecker, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	checkers := m.checkers
+	if family != "" {
+		checkers = m.familyCheckers[family]
 	}
-	return &StatusChecker{
-		moduleName: moduleName,
-		path:       fmt.Sprintf("/%s/", moduleName),
-		results:    make([]CheckResult, 0, maxResults),
-		maxResults: maxResults,
+	c, ok := checkers[module]
+	return c, ok
+}
+
+// hasFamily reports whether modules are being checked over family.
+func (m *Monitor) hasFamily(family string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.familyCheckers[family]
+	return ok
+}
+
+// monitoredModules describes every checked module for the root endpoint.
+func (m *Monitor) monitoredModules() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	modules := make(map[string]interface{}, len(m.checkers))
+	for name := range m.checkers {
+		entry := map[string]interface{}{"endpoint": fmt.Sprintf("/status/%s", name)}
+		if desc := m.modules[name].Description; desc != "" {
+			entry["description"] = desc
+		}
+		modules[name] = entry
 	}
+	return modules
 }
 
-func (sc *StatusChecker) StartPolling() {
-	ticker := time.NewTicker(pollingInterval)
-	go func() {
-		sc.performCheck() // Run first check immediately.
-		for range ticker.C {
-			sc.performCheck()
+// ignoredModules describes the discovered modules the filter rejected.
+func (m *Monitor) ignoredModules() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	modules := make(map[string]interface{}, len(m.ignored))
+	for name, info := range m.ignored {
+		entry := map[string]interface{}{"status": "ignored"}
+		if info.Description != "" {
+			entry["description"] = info.Description
 		}
-	}()
+		modules[name] = entry
+	}
+	return modules
 }
 
-func (sc *StatusChecker) performCheck() {
-	url := rsyncURL + sc.moduleName
-	cmd := execCommand("rsync", url)
-	out, err := cmd.CombinedOutput()
+// addressFamilies summarizes the health of each checked address family.
+func (m *Monitor) addressFamilies() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	families := make(map[string]interface{}, len(m.familyCheckers))
+	for family, fc := range m.familyCheckers {
+		health := familyHealth(fc)
+		health["endpoint"] = "/status/<module>?family=" + family
+		families[family] = health
+	}
+	return families
+}
 
-	newResult := CheckResult{Timestamp: time.Now()}
-	outputStr := string(out)
+// readiness reports, per component, whether the monitor has something
+// useful to serve: the module list was discovered, at least one check has
+// completed and, when STATE_FILE is set, the state can be written.
+func (m *Monitor) readiness() map[string]map[string]interface{} {
+	m.mu.RLock()
+	discovery := map[string]interface{}{
+		"ok":      !m.discoveredAt.IsZero(),
+		"modules": len(m.modules),
+	}
+	if m.discoveredAt.IsZero() {
+		discovery["detail"] = "modules not discovered yet"
+	} else {
+		discovery["discovered_at"] = m.discoveredAt
+	}
+	// A failed rediscovery leaves the previous list in use, so it is
+	// reported but does not make the monitor unready.
+	if m.discoveryErr != nil {
+		discovery["last_error"] = firstOutputLine(m.discoveryErr.Error())
+	}
+	completed := 0
+	for _, checker := range m.checkers {
+		if _, ok := checker.latest(); ok {
+			completed++
+		}
+	}
+	m.mu.RUnlock()
 
-   if err == nil {
-		   newResult.IsUp = true
-		   newResult.Message = "Operational"
-		   newResult.Error = ""
-		   newResult.HTTPStatus = http.StatusOK
-		   newResult.RsyncExitCode = 0
-   } else {
-		   newResult.IsUp = false
-		   newResult.Message = ""
-		   newResult.RsyncOutput = strings.TrimSpace(outputStr)
+	checks := map[string]interface{}{
+		"ok":              completed > 0,
+		"modules_checked": completed,
+	}
+	if completed == 0 {
+		checks["detail"] = "no check has completed yet"
+	}
 
-		   var exiterr *exec.ExitError
-		   if errors.As(err, &exiterr) {
-				   if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-						   newResult.RsyncExitCode = status.ExitStatus()
-				   }
-		   }
+	storage := map[string]interface{}{"ok": true}
+	if stateFile == "" {
+		storage["detail"] = "persistence disabled (STATE_FILE not set)"
+	} else if err := checkWritable(stateFile); err != nil {
+		storage["ok"] = false
+		storage["detail"] = err.Error()
+	} else {
+		storage["state_file"] = stateFile
+	}
 
-		   // Extrai a primeira linha do erro do rsync para o campo Error
-		   firstLine := ""
-		   lines := strings.Split(outputStr, "\n")
-		   for _, l := range lines {
-				   l = strings.TrimSpace(l)
-				   if l != "" {
-						   firstLine = l
-						   break
-				   }
-		   }
-		   if firstLine == "" {
-				   firstLine = "Erro desconhecido do rsync"
-		   }
+	return map[string]map[string]interface{}{
+		"discovery": discovery,
+		"checks":    checks,
+		"storage":   storage,
+	}
+}
 
-		   if strings.Contains(outputStr, "@ERROR: Unknown module") {
-				   newResult.HTTPStatus = http.StatusNotFound
-				   newResult.Error = firstLine
-		   } else {
-				   newResult.HTTPStatus = http.StatusInternalServerError
-				   newResult.Error = firstLine
-		   }
-   }
+// checkWritable verifies that file can be (re)written by creating and
+// removing a scratch file next to it.
+func checkWritable(file string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".probe-")
+	if err != nil {
+		return fmt.Errorf("state directory not writable: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
 
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	sc.results = append(sc.results, newResult)
-	if len(sc.results) > sc.maxResults {
-		sc.results = sc.results[1:]
+// savedState is the format of STATE_FILE: the results of every checker by
+// module name, for the default checks and for each address family, and the
+// throughput measurements.
+type savedState struct {
+	SavedAt    time.Time                           `json:"saved_at"`
+	Modules    map[string][]CheckResult            `json:"modules"`
+	Families   map[string]map[string][]CheckResult `json:"families,omitempty"`
+	Throughput []ThroughputResult                  `json:"throughput,omitempty"`
+}
+
+// saveState writes the results of every checker to file. The file is
+// replaced atomically, so a crash while writing keeps the previous state.
+func (m *Monitor) saveState(file string) error {
+	m.mu.RLock()
+	state := savedState{
+		SavedAt:  time.Now(),
+		Modules:  snapshotResults(m.checkers),
+		Families: make(map[string]map[string][]CheckResult, len(m.familyCheckers)),
+	}
+	for family, fc := range m.familyCheckers {
+		state.Families[family] = snapshotResults(fc)
+	}
+	if m.prober != nil {
+		m.prober.mu.RLock()
+		state.Throughput = append([]ThroughputResult(nil), m.prober.results...)
+		m.prober.mu.RUnlock()
+	}
+	m.mu.RUnlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
 }
 
-func (sc *StatusChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	sc.mu.RLock()
-	resultsCopy := make([]CheckResult, len(sc.results))
-	copy(resultsCopy, sc.results)
-	sc.mu.RUnlock()
+// loadState restores the results saved by saveState into the current
+// checkers. Modules no longer monitored are skipped, and a missing file
+// is not an error: it is simply the first run.
+func (m *Monitor) loadState(file string) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", file, err)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	restoreResults(m.checkers, state.Modules)
+	for family, fc := range m.familyCheckers {
+		restoreResults(fc, state.Families[family])
+	}
+	if m.prober != nil {
+		m.prober.restore(state.Throughput)
+	}
+	return nil
+}
 
-	if len(resultsCopy) > 0 {
-		latestStatus := resultsCopy[len(resultsCopy)-1].HTTPStatus
-		w.WriteHeader(latestStatus)
+func snapshotResults(checkers map[string]*StatusChecker) map[string][]CheckResult {
+	results := make(map[string][]CheckResult, len(checkers))
+	for module, checker := range checkers {
+		checker.mu.RLock()
+		results[module] = append([]CheckResult(nil), checker.results...)
+		checker.mu.RUnlock()
 	}
+	return results
+}
 
-	// Adiciona o campo 'code' com o valor do RsyncExitCode em erros
-	var resp []map[string]interface{}
-	for _, res := range resultsCopy {
-		m := make(map[string]interface{})
-		m["is_up"] = res.IsUp
-		m["success"] = res.IsUp
-		if res.IsUp {
-			m["message"] = res.Message
-		} else {
-			m["error"] = res.Error
-		}
-		m["http_status"] = res.HTTPStatus
-		m["timestamp"] = res.Timestamp
-		m["path"] = sc.path
-		if res.RsyncOutput != "" {
-			m["rsync_output"] = res.RsyncOutput
+func restoreResults(checkers map[string]*StatusChecker, saved map[string][]CheckResult) {
+	for module, results := range saved {
+		checker, ok := checkers[module]
+		if !ok {
+			continue
 		}
-		// Se for erro, coloca o code do rsync
-		if !res.IsUp {
-			m["code"] = res.RsyncExitCode
-			if res.RsyncExitCode != 0 {
-				m["rsync_exit_code"] = res.RsyncExitCode
-			}
-		} else {
-			m["code"] = 0
+		checker.mu.Lock()
+		// Saved results predate any check made since startup.
+		checker.results = append(append([]CheckResult(nil), results...), checker.results...)
+		if extra := len(checker.results) - checker.maxResults; extra > 0 {
+			checker.results = checker.results[extra:]
 		}
-		resp = append(resp, m)
+		checker.mu.Unlock()
 	}
-	json.NewEncoder(w).Encode(resp)
 }
 
 // isValidModulePath checks if the module name contains only allowed characters.
@@ -234,6 +2693,72 @@ func isValidModulePath(module string) bool {
 	return matched
 }
 
+// familyHealth summarizes the latest results of a set of checkers, one per
+// module, for the root endpoint.
+func familyHealth(checkers map[string]*StatusChecker) map[string]interface{} {
+	up, checked := 0, 0
+	var down []string
+	for module, checker := range checkers {
+		res, ok := checker.latest()
+		if !ok {
+			continue
+		}
+		checked++
+		if res.IsUp {
+			up++
+		} else {
+			down = append(down, module)
+		}
+	}
+	sort.Strings(down)
+	return map[string]interface{}{
+		"healthy":       checked > 0 && up == checked,
+		"modules_up":    up,
+		"modules_total": len(checkers),
+		"modules_down":  down,
+	}
+}
+
+// healthz answers liveness probes: the process serves HTTP and the scheduler
+// loop is ticking. Like readyz, it only looks at in-memory state and never
+// runs rsync, so probes put no load on the mirror.
+func healthz(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, r.URL.Path, map[string]map[string]interface{}{
+			"scheduler": s.health(),
+		})
+	}
+}
+
+// readyz answers readiness probes, see Monitor.readiness.
+func readyz(m *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, r.URL.Path, m.readiness())
+	}
+}
+
+// writeProbe answers a probe with 200 when every component is ok and 503
+// otherwise, detailing each component.
+func writeProbe(w http.ResponseWriter, path string, components map[string]map[string]interface{}) {
+	ok := true
+	for _, c := range components {
+		ok = ok && c["ok"] == true
+	}
+	status, statusCode := "ok", http.StatusOK
+	if !ok {
+		status, statusCode = "fail", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":       path,
+		"success":    ok,
+		"status":     status,
+		"components": components,
+	})
+}
+
 // writeJSONError envia resposta de erro JSON padronizada, incluindo o path.
 func writeJSONError(w http.ResponseWriter, statusCode int, message string, path string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -254,14 +2779,41 @@ func main() {
 	}
 	log.Printf("Discovered %d modules to monitor.", len(discoveredModules))
 
-	// Store all checkers in a map for easy lookup.
-	checkers := make(map[string]*StatusChecker)
-	for _, module := range discoveredModules {
-		checker := NewStatusChecker(module)
-		checker.StartPolling()
-		checkers[module] = checker
+	// Every periodic job shares one scheduler, which spreads them over their
+	// interval and limits how many hit the same server at once.
+	scheduler := NewScheduler(realClock{}, maxConcurrentPerServer, scheduleJitter, time.Now().UnixNano())
+
+	monitor := NewMonitor(scheduler)
+	monitor.applyDiscovery(discoveredModules)
+	for name, target := range sshTargets {
+		monitor.addSSHTarget(name, target)
+	}
+
+	var prober *ThroughputProber
+	if benchmarkFile != "" {
+		prober = NewThroughputProber(benchmarkFile)
+		monitor.prober = prober
+	}
+	if stateFile != "" {
+		if err := monitor.loadState(stateFile); err != nil {
+			log.Printf("WARN: Could not load state: %v. Starting with empty history.", err)
+		}
+	}
+	monitor.StartRediscovery(scheduler)
+	if prober != nil {
+		prober.StartPolling(scheduler)
+	}
+
+	daemonMonitor := NewDaemonMonitor(rsyncURL)
+	daemonMonitor.StartPolling(scheduler)
+
+	certMonitor := NewCertMonitor(rsyncURL)
+	if certMonitor != nil {
+		certMonitor.StartPolling(scheduler)
 	}
 
+	go scheduler.Run()
+
 	mux := http.NewServeMux()
 
 	// Handler for the root endpoint, listing available modules.
@@ -272,39 +2824,86 @@ func main() {
 			   }
 
 			   w.Header().Set("Content-Type", "application/json")
-			   endpoints := make(map[string]string)
-			   for _, module := range discoveredModules {
-					   endpoints[module] = fmt.Sprintf("/status/%s", module)
-			   }
+			   endpoints := monitor.monitoredModules()
 
-			   // Tenta obter a lista de diretórios do rsync
-			   var rsyncDirs []string
-			   out, err := execCommand("rsync", rsyncURL).CombinedOutput()
-			   if err == nil {
-					   scanner := bufio.NewScanner(strings.NewReader(string(out)))
-					   for scanner.Scan() {
-							   line := strings.TrimSpace(scanner.Text())
-							   if line == "" {
-									   continue
-							   }
-							   // Pega o nome do diretório (primeira palavra)
-							   parts := strings.Fields(line)
-							   if len(parts) > 0 {
-									   rsyncDirs = append(rsyncDirs, parts[0])
-							   }
-					   }
-			   }
+			   // Everything below comes from memory: the module list is the one
+			   // cached at the last discovery, refreshed with POST /refresh.
+			   rsyncDirs, discoveredAt := monitor.moduleListing()
 
-			   json.NewEncoder(w).Encode(map[string]interface{}{
+			   resp := map[string]interface{}{
 					   "path": "/",
 					   "success": true,
 					   "message":            "Monitoring all discovered modules. See endpoints below.",
 					   "monitored_modules":  endpoints,
 					   "polling_interval_s": pollingInterval.Seconds(),
 					   "rsync_directories":  rsyncDirs,
-			   })
+					   "rsync_directories_fetched_at": discoveredAt,
+					   "rsync_directories_age_s":      int(time.Since(discoveredAt).Seconds()),
+					   "refresh":            "POST /refresh",
+			   }
+			   if ignored := monitor.ignoredModules(); len(ignored) > 0 {
+					   resp["ignored_modules"] = ignored
+			   }
+			   if families := monitor.addressFamilies(); len(families) > 0 {
+					   resp["address_families"] = families
+			   }
+			   resp["failure_categories"] = "/categories"
+			   info := daemonMonitor.Info()
+			   resp["daemon"] = map[string]interface{}{
+					   "protocol_version": info.ProtocolVersion,
+					   "motd":             info.MOTD,
+					   "checked_at":       info.CheckedAt,
+			   }
+			   resp["servers"] = "/servers"
+			   resp["events"] = "/events"
+			   resp["healthz"] = "/healthz"
+			   resp["readyz"] = "/readyz"
+			   if certMonitor != nil {
+					   resp["tls_certificate"] = certMonitor.Status()
+			   }
+			   if prober != nil {
+					   resp["throughput"] = "/throughput"
+					   resp["benchmark_interval_s"] = benchmarkInterval.Seconds()
+			   }
+			   json.NewEncoder(w).Encode(resp)
 	   })
 
+	// On-demand module rediscovery, rate limited.
+	mux.HandleFunc("/refresh", refreshHandler(monitor))
+
+	// Results as they happen, for clients that would otherwise poll.
+	mux.HandleFunc("/events", eventsHandler(events))
+
+	// Probes for orchestrators; neither touches the rsync daemon.
+	mux.HandleFunc("/healthz", healthz(scheduler))
+	mux.HandleFunc("/readyz", readyz(monitor))
+
+	// The failure classification table, so clients can interpret 'category'.
+	mux.HandleFunc("/categories", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(failureCategories)
+	})
+
+	// Daemon protocol version and MOTD, with the history of changes.
+	mux.Handle("/servers", daemonMonitor)
+
+	// Prometheus metrics. Only the TLS certificate is exported for now.
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if certMonitor != nil {
+			writeCertMetrics(w, certMonitor.Status())
+		}
+	})
+
+	// Throughput history of the benchmark file, if configured.
+	mux.HandleFunc("/throughput", func(w http.ResponseWriter, r *http.Request) {
+		if prober == nil {
+			writeJSONError(w, http.StatusNotFound, "Throughput probe is not configured. Set BENCHMARK_FILE to enable it.", r.URL.Path)
+			return
+		}
+		prober.ServeHTTP(w, r)
+	})
+
 	// A single handler for all /status/ requests that validates input.
 	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
 		module := strings.TrimPrefix(r.URL.Path, "/status/")
@@ -317,7 +2916,13 @@ func main() {
 					   return
 			   }
 
-			   checker, found := checkers[module]
+			   family := r.URL.Query().Get("family")
+			   if family != "" && !monitor.hasFamily(family) {
+					   writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Address family '%s' is not monitored. Set CHECK_IP_FAMILIES to ipv4 and/or ipv6.", family), r.URL.Path)
+					   return
+			   }
+
+			   checker, found := monitor.checker(module, family)
 			   if !found {
 					   writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Module '%s' is not monitored.", module), r.URL.Path)
 					   return
@@ -326,8 +2931,52 @@ func main() {
 	})
 
 
-   log.Printf("Starting monitoring server on :%s using rsync URL '%s'", serverPort, rsyncURL)
-   if err := http.ListenAndServe(":"+serverPort, mux); err != nil {
-	  log.Fatalf("Server failed to start: %s", err)
-   }
+	server := &http.Server{Addr: ":" + serverPort, Handler: mux}
+	go func() {
+		log.Printf("Starting monitoring server on :%s using rsync URL '%s'", serverPort, rsyncURL)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %s", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down...", <-stop)
+	shutdown(scheduler, monitor, server)
+}
+
+// shutdown stops scheduling checks, waits up to shutdownTimeout for the ones
+// in progress (killing their rsync processes past that), saves the state and
+// finally stops the HTTP server, which keeps answering until then.
+func shutdown(scheduler *Scheduler, monitor *Monitor, server *http.Server) {
+	scheduler.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := scheduler.Wait(ctx); err != nil {
+		log.Printf("WARN: Checks still running after %v, cancelling them.", shutdownTimeout)
+		abortCommands()
+		// Killed checks return at once; only the diagnostics' own
+		// timeouts can hold them up further.
+		grace, cancelGrace := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelGrace()
+		scheduler.Wait(grace)
+	}
+
+	if stateFile != "" {
+		if err := monitor.saveState(stateFile); err != nil {
+			log.Printf("WARN: Could not save state to %s: %v", stateFile, err)
+		} else {
+			log.Printf("Saved state to %s", stateFile)
+		}
+	}
+
+	// Event streams never end on their own; close them so Shutdown
+	// does not wait for them.
+	events.close()
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelHTTP()
+	if err := server.Shutdown(httpCtx); err != nil {
+		log.Printf("WARN: HTTP server shutdown: %v", err)
+	}
+	log.Println("Shutdown complete.")
 }
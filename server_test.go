@@ -1,12 +1,30 @@
 package main
 import (
+	   "bufio"
+	   "context"
+	   "crypto/ecdsa"
+	   "crypto/elliptic"
+	   "crypto/rand"
+	   "crypto/sha256"
+	   "crypto/tls"
+	   "crypto/x509"
+	   "crypto/x509/pkix"
+	   "encoding/hex"
 	   "encoding/json"
+	   "encoding/pem"
 	   "fmt"
+	   "io"
+	   "math/big"
+	   "net"
 	   "net/http"
 	   "net/http/httptest"
 	   "os"
 	   "os/exec"
+	   "path"
+	   "path/filepath"
+	   "strconv"
 	   "strings"
+	   "sync"
 	   "testing"
 	   "time"
 )
@@ -94,6 +112,10 @@ func TestIntegration_ServerEndpoints(t *testing.T) {
 func TestMain(m *testing.M) {
 	originalExecCommand := execCommand
 	execCommand = mockExecCommand
+	// Failing checks run the diagnostic ladder; keep it off the real network.
+	lookupHost = func(host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
 	code := m.Run()
 	execCommand = originalExecCommand
 	os.Exit(code)
@@ -118,18 +140,104 @@ func TestHelperProcess(t *testing.T) {
 		args = args[i+1:]
 	}
 
+	// Keep the command and its positional arguments; remember the options.
+	var opts []string
+	var positional []string
+	for i, a := range args {
+		if i > 0 && strings.HasPrefix(a, "-") {
+			opts = append(opts, a)
+			continue
+		}
+		positional = append(positional, a)
+	}
+	args = positional
+
 	if len(args) < 2 {
 		os.Exit(1)
 	}
 
+	// rsync <src> <dest>/ is a canary transfer.
+	if len(args) == 3 {
+		if strings.HasSuffix(args[1], "/missing.txt") {
+			fmt.Fprintln(os.Stdout, `rsync: [sender] link_stat "missing.txt" (in debian) failed: No such file or directory (2)`)
+			os.Exit(23)
+		}
+		if err := os.WriteFile(filepath.Join(args[2], path.Base(args[1])), []byte(canaryContent), 0o644); err != nil {
+			os.Exit(11)
+		}
+		os.Exit(0)
+	}
+
 	rsyncURL := args[1]
-	if rsyncURL == "rsync://sagres.c3sl.ufpr.br/" {
-		fmt.Fprintln(os.Stdout, "debian          Debian Archive")
-		fmt.Fprintln(os.Stdout, "ubuntu          Ubuntu Archive")
+	if !strings.Contains(rsyncURL, "://") && strings.Contains(rsyncURL, ":") {
+		// rsync over ssh: the remote shell must be the (fake) ssh wrapper.
+		rsh := ""
+		for _, o := range opts {
+			if v, ok := strings.CutPrefix(o, "--rsh="); ok {
+				rsh = v
+			}
+		}
+		if !strings.HasPrefix(rsh, "'fake-ssh' ") {
+			fmt.Fprintf(os.Stdout, "rsync: unexpected remote shell %q\n", rsh)
+			os.Exit(1)
+		}
+		host, _, _ := strings.Cut(rsyncURL, ":")
+		switch {
+		case strings.HasSuffix(host, "badkey.example"):
+			fmt.Fprint(os.Stdout, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\r\n@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\nHost key verification failed.\r\nrsync: connection unexpectedly closed (0 bytes received so far) [Receiver]\nrsync error: unexplained error (code 255) at io.c(232) [Receiver=3.2.7]\n")
+			os.Exit(255)
+		case strings.HasSuffix(host, "denied.example"):
+			fmt.Fprint(os.Stdout, "mirror@denied.example: Permission denied (publickey).\r\nrsync: connection unexpectedly closed (0 bytes received so far) [Receiver]\nrsync error: unexplained error (code 255) at io.c(232) [Receiver=3.2.7]\n")
+			os.Exit(255)
+		case strings.HasSuffix(host, "refused.example"):
+			fmt.Fprint(os.Stdout, "ssh: connect to host refused.example port 2222: Connection refused\r\nrsync: connection unexpectedly closed (0 bytes received so far) [Receiver]\nrsync error: unexplained error (code 255) at io.c(232) [Receiver=3.2.7]\n")
+			os.Exit(255)
+		}
+		fmt.Fprintln(os.Stdout, "drwxr-xr-x          4,096 2025/07/29 14:00:00 .")
+		os.Exit(0)
+	}
+	if strings.HasSuffix(rsyncURL, "/canary.txt") {
+		fmt.Fprintf(os.Stdout, "-rw-r--r--     %10d 2025/07/29 14:00:00 canary.txt\n", len(canaryContent))
+		os.Exit(0)
+	} else if rsyncURL == "rsync://sagres.c3sl.ufpr.br/" {
+		// Like the real client, --no-motd hides the listing along with the MOTD.
+		if find(opts, "--no-motd") >= 0 {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stdout, "Welcome to the C3SL mirror")
+		fmt.Fprintln(os.Stdout, "")
+		fmt.Fprintln(os.Stdout, "  Contact: mirror@c3sl.ufpr.br")
+		fmt.Fprintln(os.Stdout, "")
+		fmt.Fprintf(os.Stdout, "%-15s\t%s\n", "debian", "Debian Archive")
+		fmt.Fprintf(os.Stdout, "%-15s\t%s\n", "ubuntu", "Ubuntu Archive")
 		os.Exit(0)
 	} else if strings.HasSuffix(rsyncURL, "nonexistent") {
 		fmt.Fprintln(os.Stdout, "@ERROR: Unknown module 'nonexistent'")
 		os.Exit(5)
+	} else if strings.HasSuffix(rsyncURL, "/private") {
+		password := os.Getenv("RSYNC_PASSWORD")
+		for _, o := range opts {
+			if file, ok := strings.CutPrefix(o, "--password-file="); ok {
+				data, _ := os.ReadFile(file)
+				password = strings.TrimSpace(string(data))
+			}
+		}
+		if !strings.Contains(rsyncURL, "alice@") || password != "s3cret" {
+			fmt.Fprintln(os.Stdout, "@ERROR: auth failed on module private")
+			os.Exit(5)
+		}
+		os.Exit(0)
+	} else if strings.HasSuffix(rsyncURL, "v6down") && find(opts, "-6") >= 0 {
+		fmt.Fprintln(os.Stdout, "rsync: failed to connect to sagres.c3sl.ufpr.br (2001:db8::1): Network is unreachable (101)")
+		os.Exit(10)
+	} else if strings.HasSuffix(rsyncURL, "/slow") {
+		// A daemon that answers, but slowly.
+		time.Sleep(300 * time.Millisecond)
+		os.Exit(0)
+	} else if strings.HasSuffix(rsyncURL, "/hang") {
+		// A daemon that never answers; only killing rsync ends the check.
+		time.Sleep(time.Minute)
+		os.Exit(0)
 	} else if strings.HasSuffix(rsyncURL, "internalerror") {
 		fmt.Fprintln(os.Stdout, "@ERROR: chroot failed")
 		os.Exit(12)
@@ -138,6 +246,9 @@ func TestHelperProcess(t *testing.T) {
 	}
 }
 
+// canaryContent is what the helper process "transfers" as a canary file.
+const canaryContent = "rsyncuptime canary\n"
+
 func find(slice []string, val string) int {
 	for i, item := range slice {
 		if item == val {
@@ -398,3 +509,1472 @@ func TestRootHandler(t *testing.T) {
 		t.Errorf("Expected debian endpoint to be '/status/debian', got %v", modulesMap["debian"])
 	}
 }
+
+
+// --- Canary tests ---
+func TestParseCanaryFiles(t *testing.T) {
+	sum := sha256.Sum256([]byte(canaryContent))
+	hexSum := hex.EncodeToString(sum[:])
+
+	files, err := parseCanaryFiles("debian=README, ubuntu=/ls-lR.gz:1234:" + strings.ToUpper(hexSum))
+	if err != nil {
+		t.Fatalf("parseCanaryFiles failed: %v", err)
+	}
+	if got := files["debian"]; got.Path != "README" || got.Size != -1 || got.SHA256 != "" {
+		t.Errorf("debian: unexpected config %+v", got)
+	}
+	if got := files["ubuntu"]; got.Path != "ls-lR.gz" || got.Size != 1234 || got.SHA256 != hexSum {
+		t.Errorf("ubuntu: unexpected config %+v", got)
+	}
+
+	for _, spec := range []string{"debian", "bad/name=README", "debian=", "debian=README:abc", "debian=README:1:nothex", "debian=a:1:2:3"} {
+		if _, err := parseCanaryFiles(spec); err == nil {
+			t.Errorf("parseCanaryFiles(%q): expected error", spec)
+		}
+	}
+}
+
+func TestPerformCheckCanary(t *testing.T) {
+	sum := sha256.Sum256([]byte(canaryContent))
+	hexSum := hex.EncodeToString(sum[:])
+
+	testCases := []struct {
+		name    string
+		cfg     canaryConfig
+		wantUp  bool
+		wantErr string
+	}{
+		{"upstream size", canaryConfig{Path: "canary.txt", Size: -1}, true, ""},
+		{"expected size and checksum", canaryConfig{Path: "canary.txt", Size: int64(len(canaryContent)), SHA256: hexSum}, true, ""},
+		{"size mismatch", canaryConfig{Path: "canary.txt", Size: 1}, false, "size mismatch"},
+		{"checksum mismatch", canaryConfig{Path: "canary.txt", Size: -1, SHA256: strings.Repeat("0", 64)}, false, "sha256 mismatch"},
+		{"transfer failure", canaryConfig{Path: "missing.txt", Size: -1}, false, "No such file or directory"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := NewStatusChecker("debian")
+			cfg := tc.cfg
+			checker.canary = &cfg
+			checker.performCheck()
+
+			res := checker.results[len(checker.results)-1]
+			if res.IsUp != tc.wantUp {
+				t.Fatalf("expected IsUp %v, got %+v", tc.wantUp, res)
+			}
+			if res.Canary == nil {
+				t.Fatal("expected canary result")
+			}
+			if tc.wantUp {
+				if res.Canary.SHA256 != hexSum || res.Canary.Bytes != int64(len(canaryContent)) {
+					t.Errorf("unexpected canary result %+v", res.Canary)
+				}
+				return
+			}
+			if res.Category != categoryContentError {
+				t.Errorf("expected category %q, got %q", categoryContentError, res.Category)
+			}
+			if !strings.Contains(res.Error, tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %q", tc.wantErr, res.Error)
+			}
+		})
+	}
+}
+
+func TestThroughputProbe(t *testing.T) {
+	prober := NewThroughputProber("debian/canary.txt")
+	prober.performProbe()
+
+	ts := httptest.NewServer(prober)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer res.Body.Close()
+
+	var results []ThroughputResult
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 throughput result, got %d", len(results))
+	}
+	if results[0].Error != "" || results[0].Bytes != int64(len(canaryContent)) || results[0].File != "canary.txt" {
+		t.Errorf("Unexpected throughput result %+v", results[0])
+	}
+}
+
+// --- Diagnostic ladder tests ---
+
+// startFakeDaemon listens on a local port and greets every client with the
+// given line, returning the rsync URL of a module on it.
+func startFakeDaemon(t *testing.T, greeting string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, greeting)
+			conn.Close()
+		}
+	}()
+	return "rsync://" + ln.Addr().String() + "/debian"
+}
+
+func TestDiagnose(t *testing.T) {
+	// A port that was just released is as good as a firewalled one.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	closedURL := "rsync://" + ln.Addr().String() + "/debian"
+	ln.Close()
+
+	testCases := []struct {
+		name      string
+		url       string
+		wantLayer string
+		wantSteps int
+	}{
+		{"dns failure", "rsync://mirror.invalid/debian", layerDNS, 1},
+		{"tcp refused", closedURL, layerTCP, 1},
+		{"bad greeting", startFakeDaemon(t, "HTTP/1.1 400 Bad Request\r\n"), layerGreeting, 2},
+		{"module failure", startFakeDaemon(t, "@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4\n"), layerModule, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			steps, layer := diagnose(tc.url, "@ERROR: access denied", "")
+			if layer != tc.wantLayer {
+				t.Errorf("expected failed layer %q, got %q (%+v)", tc.wantLayer, layer, steps)
+			}
+			if len(steps) != tc.wantSteps {
+				t.Fatalf("expected %d steps, got %+v", tc.wantSteps, steps)
+			}
+			last := steps[len(steps)-1]
+			if last.OK || last.Layer != tc.wantLayer {
+				t.Errorf("expected last step to be a failed %q, got %+v", tc.wantLayer, last)
+			}
+			for _, step := range steps[:len(steps)-1] {
+				if !step.OK {
+					t.Errorf("expected step %q before the failing layer to be ok", step.Layer)
+				}
+			}
+		})
+	}
+}
+
+func TestPerformCheckRecordsFailedLayer(t *testing.T) {
+	checker := NewStatusChecker("nonexistent")
+	checker.performCheck()
+
+	res := checker.results[len(checker.results)-1]
+	if res.IsUp || res.HTTPStatus != http.StatusNotFound {
+		t.Fatalf("Expected a 404 failure, got %+v", res)
+	}
+	// The mocked resolver fails every lookup.
+	if res.FailedLayer != layerDNS || len(res.Diagnostics) != 1 {
+		t.Errorf("Expected failed layer %q, got %q (%+v)", layerDNS, res.FailedLayer, res.Diagnostics)
+	}
+}
+
+func TestParseIPFamilies(t *testing.T) {
+	families, err := parseIPFamilies(" IPv4, ipv6,ipv4 ")
+	if err != nil {
+		t.Fatalf("parseIPFamilies failed: %v", err)
+	}
+	if len(families) != 2 || families[0] != familyIPv4 || families[1] != familyIPv6 {
+		t.Errorf("Unexpected families %v", families)
+	}
+	if _, err := parseIPFamilies("ipv4,ipx"); err == nil {
+		t.Error("Expected error for unknown family")
+	}
+}
+
+func TestPerFamilyChecks(t *testing.T) {
+	checkers := make(map[string]*StatusChecker)
+	for _, family := range []string{familyIPv4, familyIPv6} {
+		checker := NewStatusChecker("v6down")
+		checker.family = family
+		checker.performCheck()
+		checkers[family] = checker
+	}
+
+	if res, _ := checkers[familyIPv4].latest(); !res.IsUp {
+		t.Errorf("Expected ipv4 check to be up, got %+v", res)
+	}
+	if res, _ := checkers[familyIPv6].latest(); res.IsUp || res.RsyncExitCode != 10 {
+		t.Errorf("Expected ipv6 check to be down with exit code 10, got %+v", res)
+	}
+
+	health := familyHealth(map[string]*StatusChecker{"v6down": checkers[familyIPv6]})
+	if health["healthy"] != false || health["modules_up"] != 0 {
+		t.Errorf("Expected unhealthy ipv6 family, got %v", health)
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	addrs := []string{"192.0.2.1", "2001:db8::1", "198.51.100.7"}
+	if got := filterFamily(addrs, familyIPv4); len(got) != 2 || got[0] != "192.0.2.1" {
+		t.Errorf("ipv4: unexpected %v", got)
+	}
+	if got := filterFamily(addrs, familyIPv6); len(got) != 1 || got[0] != "2001:db8::1" {
+		t.Errorf("ipv6: unexpected %v", got)
+	}
+	if got := filterFamily(addrs, ""); len(got) != 3 {
+		t.Errorf("any: unexpected %v", got)
+	}
+}
+
+// --- Failure classification tests ---
+func TestClassifyFailure(t *testing.T) {
+	// Outputs captured from rsync 3.2.7 against misbehaving daemons.
+	testCases := []struct {
+		name         string
+		exitCode     int
+		output       string
+		wantCategory string
+		wantStatus   int
+	}{
+		{"unknown module", 5, "@ERROR: Unknown module 'foo'\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", "unknown_module", http.StatusNotFound},
+		{"max connections", 5, "@ERROR: max connections (10) reached -- try again later\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", "max_connections", http.StatusServiceUnavailable},
+		{"access denied", 5, "@ERROR: access denied to debian from mirror.example.org (192.0.2.10)\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", "access_denied", http.StatusForbidden},
+		{"chroot failed", 5, "@ERROR: chroot failed\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", "chroot_failed", http.StatusInternalServerError},
+		{"protocol error", 5, "rsync: did not see server greeting\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", "protocol_error", http.StatusBadGateway},
+		{"connection refused", 10, "rsync: failed to connect to sagres.c3sl.ufpr.br (200.17.202.1): Connection refused (111)\nrsync error: error in socket IO (code 10) at clientserver.c(139) [Receiver=3.2.7]", "socket_io", http.StatusServiceUnavailable},
+		{"stream error", 12, "rsync: [Receiver] read error: Connection reset by peer (104)\nrsync error: error in rsync protocol data stream (code 12) at io.c(231) [Receiver=3.2.7]", "stream_error", http.StatusBadGateway},
+		{"partial transfer", 23, "rsync: [sender] opendir \"private\" (in debian) failed: Permission denied (13)\nrsync error: some files/attrs were not transferred (see previous errors) (code 23) at main.c(1865) [Receiver=3.2.7]", "partial_transfer", http.StatusInternalServerError},
+		{"io timeout", 30, "[Receiver] io timeout after 30 seconds -- exiting\nrsync error: timeout in data send/receive (code 30) at io.c(197) [Receiver=3.2.7]", "timeout", http.StatusGatewayTimeout},
+		{"connect timeout", 35, "rsync error: timeout waiting for daemon connection (code 35) at socket.c(278) [Receiver=3.2.7]", "connect_timeout", http.StatusGatewayTimeout},
+		{"unknown exit code", 99, "something unexpected", categoryUnknown, http.StatusInternalServerError},
+		// The MOTD is part of the output; only rsync's and ssh's own lines count.
+		{"motd mentioning max connections", 5, "Welcome to the C3SL mirror\nPlease keep max connections per host at 2.\n\n@ERROR: auth failed on module private\nrsync error: error starting client-server protocol (code 5) at main.c(1863) [Receiver=3.2.7]", categoryAuthFailed, http.StatusUnauthorized},
+		{"motd and missing module", 10, "Welcome to the C3SL mirror\nmax connections: 10\nrsync: failed to connect to sagres.c3sl.ufpr.br (200.17.202.1): Connection refused (111)\nrsync error: error in socket IO (code 10) at clientserver.c(139) [Receiver=3.2.7]", "socket_io", http.StatusServiceUnavailable},
+		{"password file", 5, "ERROR: password file must not be other-accessible\nrsync error: syntax or usage error (code 1) at authenticate.c(196) [Receiver=3.2.7]", categoryAuthFailed, http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := classifyFailure(tc.exitCode, tc.output)
+			if got.Name != tc.wantCategory || got.HTTPStatus != tc.wantStatus {
+				t.Errorf("classifyFailure(%d) = %s/%d; want %s/%d", tc.exitCode, got.Name, got.HTTPStatus, tc.wantCategory, tc.wantStatus)
+			}
+			if got.Severity == "" {
+				t.Errorf("category %s has no severity", got.Name)
+			}
+		})
+	}
+}
+
+func TestRetryAfterOnMaxConnections(t *testing.T) {
+	checker := NewStatusChecker("debian")
+	checker.results = []CheckResult{{
+		IsUp:       false,
+		Error:      "@ERROR: max connections (10) reached -- try again later",
+		HTTPStatus: http.StatusServiceUnavailable,
+		Category:   "max_connections",
+	}}
+	rr := httptest.NewRecorder()
+	checker.ServeHTTP(rr, httptest.NewRequest("GET", "/status/debian", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", got)
+	}
+}
+
+// --- Authenticated module tests ---
+func TestParseCredentials(t *testing.T) {
+	creds, err := parseCredentials("private=alice:file:/etc/rsyncuptime/private.pass, other=bob:env:OTHER_PASS")
+	if err != nil {
+		t.Fatalf("parseCredentials failed: %v", err)
+	}
+	if c := creds["private"]; c.User != "alice" || c.PasswordFile != "/etc/rsyncuptime/private.pass" || c.PasswordEnv != "" {
+		t.Errorf("private: unexpected credentials %+v", c)
+	}
+	if c := creds["other"]; c.User != "bob" || c.PasswordEnv != "OTHER_PASS" || c.PasswordFile != "" {
+		t.Errorf("other: unexpected credentials %+v", c)
+	}
+
+	for _, spec := range []string{"private=alice", "private=alice:file:", "private=alice:vault:x", "bad/name=alice:env:X", "private=:env:X"} {
+		if _, err := parseCredentials(spec); err == nil {
+			t.Errorf("parseCredentials(%q): expected error", spec)
+		}
+	}
+}
+
+func TestPerformCheckWithCredentials(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "private.pass")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("write password file: %v", err)
+	}
+	t.Setenv("TEST_RSYNC_PASSWORD", "s3cret")
+	t.Setenv("TEST_WRONG_PASSWORD", "guess")
+
+	testCases := []struct {
+		name   string
+		creds  *credentials
+		wantUp bool
+	}{
+		{"password file", &credentials{User: "alice", PasswordFile: passwordFile}, true},
+		{"password env", &credentials{User: "alice", PasswordEnv: "TEST_RSYNC_PASSWORD"}, true},
+		{"wrong password", &credentials{User: "alice", PasswordEnv: "TEST_WRONG_PASSWORD"}, false},
+		{"no credentials", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := NewStatusChecker("private")
+			checker.creds = tc.creds
+			checker.performCheck()
+
+			res, _ := checker.latest()
+			if res.IsUp != tc.wantUp {
+				t.Fatalf("Expected IsUp %v, got %+v", tc.wantUp, res)
+			}
+			if tc.wantUp {
+				return
+			}
+			if res.Category != categoryAuthFailed || res.HTTPStatus != http.StatusUnauthorized {
+				t.Errorf("Expected auth_failed/401, got %s/%d", res.Category, res.HTTPStatus)
+			}
+			if strings.Contains(res.RsyncOutput, "s3cret") || strings.Contains(res.Error, "s3cret") {
+				t.Error("Password leaked into the check result")
+			}
+		})
+	}
+}
+
+func TestModuleURLIncludesUser(t *testing.T) {
+	checker := NewStatusChecker("private")
+	checker.creds = &credentials{User: "alice", PasswordEnv: "X"}
+	if got, want := checker.moduleURL(), "rsync://alice@sagres.c3sl.ufpr.br/private"; got != want {
+		t.Errorf("moduleURL() = %q; want %q", got, want)
+	}
+}
+
+// --- SSH transport tests ---
+func TestParseSSHTargets(t *testing.T) {
+	targets, err := parseSSHTargets("internal=mirror@internal.example:/srv/mirror, lab=lab.example:/data")
+	if err != nil {
+		t.Fatalf("parseSSHTargets failed: %v", err)
+	}
+	if targets["internal"] != "mirror@internal.example:/srv/mirror" || targets["lab"] != "lab.example:/data" {
+		t.Errorf("Unexpected targets %v", targets)
+	}
+	for _, spec := range []string{"internal", "internal=host", "internal=host:", "internal=mirror@:/srv", "bad/name=host:/srv"} {
+		if _, err := parseSSHTargets(spec); err == nil {
+			t.Errorf("parseSSHTargets(%q): expected error", spec)
+		}
+	}
+}
+
+func TestSSHRemoteShell(t *testing.T) {
+	defer func(cmd, id, kh, port string) {
+		sshCommand, sshIdentityFile, sshKnownHostsFile, sshPort = cmd, id, kh, port
+	}(sshCommand, sshIdentityFile, sshKnownHostsFile, sshPort)
+	sshCommand, sshIdentityFile, sshKnownHostsFile, sshPort = "fake-ssh", "/etc/rsync uptime/id_ed25519", "/srv/o'brien/known_hosts", "2222"
+
+	// Every argument is quoted, so that paths with spaces stay one argument.
+	got := sshRemoteShell()
+	for _, want := range []string{"'fake-ssh' ", "'-p' '2222'", "'-i' '/etc/rsync uptime/id_ed25519'", "'UserKnownHostsFile=/srv/o''brien/known_hosts'", "'BatchMode=yes'", "'StrictHostKeyChecking=yes'"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("sshRemoteShell() = %q; missing %q", got, want)
+		}
+	}
+}
+
+func TestPerformCheckOverSSH(t *testing.T) {
+	defer func(cmd string) { sshCommand = cmd }(sshCommand)
+	sshCommand = "fake-ssh"
+
+	testCases := []struct {
+		target       string
+		wantUp       bool
+		wantCategory string
+	}{
+		{"mirror@internal.example:/srv/mirror", true, ""},
+		{"mirror@badkey.example:/srv/mirror", false, categorySSHHostKey},
+		{"mirror@denied.example:/srv/mirror", false, categorySSHAuthDenied},
+		{"mirror@refused.example:/srv/mirror", false, "ssh_connection_failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.target, func(t *testing.T) {
+			checker := NewStatusChecker("internal")
+			checker.sshTarget = tc.target
+			checker.performCheck()
+
+			res, _ := checker.latest()
+			if res.IsUp != tc.wantUp || res.Category != tc.wantCategory {
+				t.Errorf("Expected up=%v category=%q, got up=%v category=%q (%s)", tc.wantUp, tc.wantCategory, res.IsUp, res.Category, res.RsyncOutput)
+			}
+		})
+	}
+}
+
+// --- rsyncs:// (TLS) tests ---
+func TestPlainRsyncUsesRsyncSSL(t *testing.T) {
+	defer func(ca string) { tlsCAFile = ca }(tlsCAFile)
+	tlsCAFile = "/etc/ssl/mirror-ca.pem"
+
+	cmd := plainRsync("--no-motd", "rsyncs://mirror.example:8874/debian")
+	if i := find(cmd.Args, "rsync-ssl"); i < 0 || cmd.Args[len(cmd.Args)-1] != "rsync://mirror.example/debian" {
+		t.Errorf("Expected rsync-ssl with a rsync:// URL, got %v", cmd.Args)
+	}
+	if find(cmd.Env, "RSYNC_SSL_PORT=8874") < 0 || find(cmd.Env, "RSYNC_SSL_CA_CERT=/etc/ssl/mirror-ca.pem") < 0 {
+		t.Errorf("Expected TLS port and CA bundle in environment, got %v", cmd.Env)
+	}
+
+	cmd = plainRsync("rsync://mirror.example/debian")
+	if find(cmd.Args, "rsync") < 0 || find(cmd.Env, "RSYNC_SSL_PORT=8874") >= 0 {
+		t.Errorf("Expected plain rsync for rsync:// URLs, got %v %v", cmd.Args, cmd.Env)
+	}
+}
+
+func TestCertState(t *testing.T) {
+	now := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		notAfter time.Time
+		want     string
+	}{
+		{now.Add(90 * 24 * time.Hour), certStateOK},
+		{now.Add(10 * 24 * time.Hour), certStateWarning},
+		{now.Add(-time.Hour), certStateExpired},
+	}
+	for _, tc := range testCases {
+		if _, got := certState(tc.notAfter, now, 14); got != tc.want {
+			t.Errorf("certState(%v) = %s; want %s", tc.notAfter, got, tc.want)
+		}
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "https://"))
+
+	defer func(ca string) { tlsCAFile = ca }(tlsCAFile)
+
+	// Without the test CA the certificate must not validate, but its expiry
+	// is still known.
+	tlsCAFile = ""
+	if status := checkCertificate(host, port, time.Now()); status.State != certStateError || status.NotAfter.IsZero() || status.DaysRemaining <= 0 {
+		t.Errorf("Expected validation error with expiry date without CA bundle, got %+v", status)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("write CA bundle: %v", err)
+	}
+	tlsCAFile = caFile
+	status := checkCertificate(host, port, time.Now())
+	if status.State != certStateOK || status.DaysRemaining <= 0 {
+		t.Fatalf("Expected valid certificate, got %+v", status)
+	}
+
+	var metrics strings.Builder
+	writeCertMetrics(&metrics, status)
+	if !strings.Contains(metrics.String(), `rsyncuptime_tls_cert_expiry_days{host="127.0.0.1"}`) ||
+		!strings.Contains(metrics.String(), `rsyncuptime_tls_cert_valid{host="127.0.0.1"} 1`) {
+		t.Errorf("Unexpected metrics output:\n%s", metrics.String())
+	}
+}
+
+// startCertServer serves a self-signed certificate for 127.0.0.1, valid until
+// notAfter, and returns its address and the certificate as a PEM CA bundle.
+func startCertServer(t *testing.T, notAfter time.Time) (host, port, caFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rsync.example"},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("write CA bundle: %v", err)
+	}
+	host, port, _ = net.SplitHostPort(strings.TrimPrefix(ts.URL, "https://"))
+	return host, port, caFile
+}
+
+func TestCheckCertificateExpired(t *testing.T) {
+	host, port, caFile := startCertServer(t, time.Now().Add(-48*time.Hour))
+	defer func(ca string) { tlsCAFile = ca }(tlsCAFile)
+	tlsCAFile = caFile
+
+	status := checkCertificate(host, port, time.Now())
+	if status.State != certStateExpired || status.Error != "" {
+		t.Fatalf("Expected expired certificate without other errors, got %+v", status)
+	}
+	if status.DaysRemaining > -1.9 || status.DaysRemaining < -2.1 {
+		t.Errorf("Expected about -2 days remaining, got %.2f", status.DaysRemaining)
+	}
+
+	var metrics strings.Builder
+	writeCertMetrics(&metrics, status)
+	if !strings.Contains(metrics.String(), `rsyncuptime_tls_cert_expiry_days{host="127.0.0.1"} -2.00`) ||
+		!strings.Contains(metrics.String(), `rsyncuptime_tls_cert_valid{host="127.0.0.1"} 0`) {
+		t.Errorf("Unexpected metrics output:\n%s", metrics.String())
+	}
+}
+
+func TestCheckCertificateUntrusted(t *testing.T) {
+	host, port, _ := startCertServer(t, time.Now().Add(90*24*time.Hour))
+	_, _, otherCA := startCertServer(t, time.Now().Add(90*24*time.Hour))
+	defer func(ca string) { tlsCAFile = ca }(tlsCAFile)
+	tlsCAFile = otherCA
+
+	status := checkCertificate(host, port, time.Now())
+	if status.State != certStateError || !strings.Contains(status.Error, "unknown authority") {
+		t.Fatalf("Expected an untrusted certificate error, got %+v", status)
+	}
+	if status.NotAfter.IsZero() || status.DaysRemaining < 89 {
+		t.Errorf("Expected the expiry date of an untrusted certificate, got %+v", status)
+	}
+
+	var metrics strings.Builder
+	writeCertMetrics(&metrics, status)
+	if !strings.Contains(metrics.String(), `rsyncuptime_tls_cert_expiry_days{host="127.0.0.1"}`) ||
+		!strings.Contains(metrics.String(), `rsyncuptime_tls_cert_valid{host="127.0.0.1"} 0`) {
+		t.Errorf("Unexpected metrics output:\n%s", metrics.String())
+	}
+}
+
+// --- Daemon greeting and MOTD tests ---
+
+// startMOTDDaemon accepts clients like a real daemon: it greets them, waits
+// for their version and then sends the current MOTD before waiting for a
+// module name that never comes.
+func startMOTDDaemon(t *testing.T, version string, motd *string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintf(conn, "@RSYNCD: %s sha512 sha256 sha1 md5 md4\n", version)
+				r := bufio.NewReader(conn)
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				fmt.Fprint(conn, *motd)
+				r.ReadString('\n') // Block until the client hangs up.
+			}(conn)
+		}
+	}()
+	return "rsync://" + ln.Addr().String() + "/"
+}
+
+func TestDaemonMonitor(t *testing.T) {
+	defer func(d time.Duration) { motdIdleTimeout = d }(motdIdleTimeout)
+	motdIdleTimeout = 100 * time.Millisecond
+
+	motd := "Welcome to the C3SL mirror\n\nMaintenance on Saturday 10:00\n"
+	dm := NewDaemonMonitor(startMOTDDaemon(t, "31.0", &motd))
+
+	dm.performCheck()
+	info := dm.Info()
+	if info.Error != "" || info.ProtocolVersion != "31.0" {
+		t.Fatalf("Unexpected daemon info %+v", info)
+	}
+	if want := "Welcome to the C3SL mirror\n\nMaintenance on Saturday 10:00"; info.MOTD != want {
+		t.Errorf("MOTD = %q; want %q", info.MOTD, want)
+	}
+
+	// An unchanged MOTD is not recorded again; a new one is.
+	dm.performCheck()
+	motd = "Welcome to the C3SL mirror\n"
+	dm.performCheck()
+
+	rr := httptest.NewRecorder()
+	dm.ServeHTTP(rr, httptest.NewRequest("GET", "/servers", nil))
+	var servers []struct {
+		ProtocolVersion string         `json:"protocol_version"`
+		MOTD            string         `json:"motd"`
+		History         []DaemonChange `json:"history"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&servers); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(servers) != 1 || servers[0].MOTD != "Welcome to the C3SL mirror" {
+		t.Fatalf("Unexpected /servers response %+v", servers)
+	}
+	if len(servers[0].History) != 2 {
+		t.Errorf("Expected 2 history entries, got %+v", servers[0].History)
+	}
+}
+
+// --- Module discovery tests ---
+
+// The helper prints a MOTD before the listing, and nothing with --no-motd.
+func TestDiscoverModulesKeepsDescriptions(t *testing.T) {
+	modules, err := discoverModules(rsyncURL)
+	if err != nil {
+		t.Fatalf("discoverModules failed: %v", err)
+	}
+	want := []ModuleInfo{{Name: "debian", Description: "Debian Archive"}, {Name: "ubuntu", Description: "Ubuntu Archive"}}
+	if len(modules) != len(want) {
+		t.Fatalf("Expected %d modules, got %+v", len(want), modules)
+	}
+	for i := range want {
+		if modules[i] != want[i] {
+			t.Errorf("module %d = %+v; want %+v", i, modules[i], want[i])
+		}
+	}
+}
+
+func TestMonitorRediscovery(t *testing.T) {
+	monitor := NewMonitor(nil)
+	var started []string
+	monitor.start = func(sc *StatusChecker) { started = append(started, sc.moduleName) }
+
+	monitor.applyDiscovery([]ModuleInfo{{Name: "debian", Description: "Debian Archive"}})
+	monitor.addSSHTarget("internal", "mirror@internal.example:/srv/mirror")
+	monitor.applyDiscovery([]ModuleInfo{
+		{Name: "debian", Description: "Debian GNU/Linux archive"},
+		{Name: "ubuntu", Description: "Ubuntu Archive"},
+		{Name: "internal", Description: "Clashes with the SSH target"},
+	})
+
+	if len(started) != 3 {
+		t.Errorf("Expected one checker started per module and target, got %v", started)
+	}
+	modules := monitor.monitoredModules()
+	debian, ok := modules["debian"].(map[string]interface{})
+	if !ok || debian["description"] != "Debian GNU/Linux archive" || debian["endpoint"] != "/status/debian" {
+		t.Errorf("Expected updated debian entry, got %v", modules["debian"])
+	}
+	if _, ok := modules["ubuntu"]; !ok {
+		t.Error("Expected newly discovered ubuntu module to be monitored")
+	}
+	if c, ok := monitor.checker("internal", ""); !ok || c.sshTarget == "" {
+		t.Error("Expected SSH target to keep its name")
+	}
+}
+
+// --- Module filter tests ---
+func TestModuleFilter(t *testing.T) {
+	filter, err := parseModuleFilter("debian*, re:ubuntu(-ports)?, internal-*", "*-old, re:.*internal.*", "internal-mirror, hidden")
+	if err != nil {
+		t.Fatalf("parseModuleFilter failed: %v", err)
+	}
+	testCases := map[string]bool{
+		"debian":          true,
+		"debian-security": true,
+		"debian-old":      false, // excluded by glob
+		"ubuntu":          true,
+		"ubuntu-ports":    true,
+		"ubuntu-releases": false, // not included
+		"internal-cache":  false, // excluded by regexp
+		"internal-mirror": true,  // explicitly listed
+		"hidden":          true,  // explicitly listed, never discovered
+		"gentoo":          false,
+	}
+	for name, want := range testCases {
+		if got := filter.allows(name); got != want {
+			t.Errorf("allows(%q) = %v; want %v", name, got, want)
+		}
+	}
+
+	for _, bad := range [][3]string{{"[", "", ""}, {"", "re:(", ""}, {"", "", "bad/name"}} {
+		if _, err := parseModuleFilter(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("parseModuleFilter(%q): expected error", bad)
+		}
+	}
+}
+
+func TestMonitorAppliesFilter(t *testing.T) {
+	filter, err := parseModuleFilter("", "internal", "hidden")
+	if err != nil {
+		t.Fatalf("parseModuleFilter failed: %v", err)
+	}
+	monitor := NewMonitor(nil)
+	monitor.filter = filter
+	monitor.start = nil
+
+	monitor.applyDiscovery([]ModuleInfo{{Name: "debian"}, {Name: "internal", Description: "Internal only"}})
+
+	modules := monitor.monitoredModules()
+	if _, ok := modules["debian"]; !ok {
+		t.Error("Expected debian to be monitored")
+	}
+	if _, ok := modules["hidden"]; !ok {
+		t.Error("Expected explicitly listed module to be monitored even though it was not discovered")
+	}
+	if _, ok := modules["internal"]; ok {
+		t.Error("Expected excluded module not to be monitored")
+	}
+	ignored, ok := monitor.ignoredModules()["internal"].(map[string]interface{})
+	if !ok || ignored["status"] != "ignored" || ignored["description"] != "Internal only" {
+		t.Errorf("Expected internal to be reported as ignored, got %v", monitor.ignoredModules())
+	}
+}
+
+// --- Scheduler tests ---
+
+// fakeClock only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time) // The tests drive the scheduler with tick().
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSchedulerJitterSpreadsFirstRuns(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	interval := 5 * time.Minute
+
+	firstRuns := func(seed int64) []time.Time {
+		s := NewScheduler(clk, 4, 1.0, seed)
+		var next []time.Time
+		for i := 0; i < 10; i++ {
+			j := s.Add(fmt.Sprintf("job%d", i), "mirror", interval, func() {})
+			next = append(next, j.next)
+		}
+		return next
+	}
+
+	a, b := firstRuns(42), firstRuns(42)
+	distinct := make(map[time.Time]bool)
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			t.Errorf("job%d: first run differs for the same seed: %v vs %v", i, a[i], b[i])
+		}
+		if offset := a[i].Sub(clk.Now()); offset < 0 || offset >= schedulerStartupWindow {
+			t.Errorf("job%d: first run offset %v outside [0, %v)", i, offset, schedulerStartupWindow)
+		}
+		distinct[a[i]] = true
+	}
+	if len(distinct) < 5 {
+		t.Errorf("Expected first runs to be spread across the startup window, got %v", a)
+	}
+
+	// A short interval keeps the first run within jitter times the interval.
+	s := NewScheduler(clk, 4, 0.5, 1)
+	if j := s.Add("job", "mirror", 10*time.Second, func() {}); j.next.Sub(clk.Now()) >= 5*time.Second {
+		t.Errorf("Expected first run within 5s, got %v", j.next.Sub(clk.Now()))
+	}
+
+	// Without jitter everything is due immediately.
+	s = NewScheduler(clk, 4, 0, 1)
+	if j := s.Add("job", "mirror", interval, func() {}); !j.next.Equal(clk.Now()) {
+		t.Errorf("Expected no offset without jitter, got %v", j.next.Sub(clk.Now()))
+	}
+}
+
+func TestSchedulerRunsEveryJobWithinFirstInterval(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 4, 1.0, 7)
+	interval := time.Hour
+
+	runs := make(chan string, 100)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("job%d", i)
+		s.Add(name, "mirror", interval, func() { runs <- name })
+	}
+
+	start := clk.Now()
+	for clk.Now().Sub(start) < interval-time.Second {
+		s.tick()
+		clk.Advance(time.Second)
+	}
+
+	got := map[string]int{}
+	for len(got) < 20 {
+		select {
+		case name := <-runs:
+			got[name]++
+		case <-time.After(time.Second):
+			t.Fatalf("Expected all 20 jobs to run before %v, got %v", interval, got)
+		}
+	}
+	for name, n := range got {
+		if n != 1 {
+			t.Errorf("%s: expected 1 run within the first interval, got %d", name, n)
+		}
+	}
+}
+
+func TestSchedulerLimitsConcurrencyPerServer(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 2, 0, 1)
+
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	var mu sync.Mutex
+	running, peak := 0, 0
+	job := func(name string) func() {
+		return func() {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			started <- name
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < 4; i++ {
+		s.Add(fmt.Sprintf("mirror%d", i), "mirror", time.Minute, job("mirror"))
+	}
+	s.Add("other", "other", time.Minute, job("other"))
+
+	if wait := s.tick(); wait != time.Minute {
+		t.Errorf("Expected next wake-up in 1m, got %v", wait)
+	}
+
+	// Two mirror jobs and the other server's job start; the rest wait.
+	got := map[string]int{}
+	for i := 0; i < 3; i++ {
+		got[<-started]++
+	}
+	select {
+	case name := <-started:
+		t.Fatalf("Job for %s started beyond the per-server limit", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got["mirror"] != 2 || got["other"] != 1 {
+		t.Errorf("Expected 2 mirror jobs and 1 other job, got %v", got)
+	}
+
+	// A tick while everything is still running does not start duplicates.
+	clk.Advance(time.Minute)
+	s.tick()
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	if peak > 3 {
+		t.Errorf("Expected at most 3 concurrent jobs, saw %d", peak)
+	}
+}
+
+// --- Shutdown tests ---
+
+func TestSchedulerStopWaitsForJobs(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 4, 0, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	runs := 0
+	s.Add("slow", "mirror", time.Minute, func() {
+		runs++
+		close(started)
+		<-release
+	})
+	s.tick()
+	<-started
+
+	s.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx); err == nil {
+		t.Fatal("Expected Wait to time out while the job is running")
+	}
+
+	close(release)
+	if err := s.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed after the job finished: %v", err)
+	}
+
+	// Nothing is started once stopped, and Run returns at once.
+	clk.Advance(time.Hour)
+	s.tick()
+	finished := make(chan struct{})
+	go func() {
+		s.Run()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+	if runs != 1 {
+		t.Errorf("Expected 1 run, got %d", runs)
+	}
+}
+
+func TestStatusCheckerStop(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 4, 0, 1)
+	checker := NewStatusChecker("debian")
+	checker.StartPolling(s)
+
+	s.tick()
+	// Stop waits for the check that just started.
+	checker.Stop()
+	if n := len(checker.results); n != 1 {
+		t.Fatalf("Expected the check in progress to complete, got %d results", n)
+	}
+
+	clk.Advance(time.Hour)
+	s.tick()
+	s.Stop()
+	s.Wait(context.Background())
+	if n := len(checker.results); n != 1 {
+		t.Errorf("Expected no checks after Stop, got %d results", n)
+	}
+}
+
+func TestAbortCommandsKillsChecks(t *testing.T) {
+	originalCtx, originalAbort := commandsCtx, abortCommands
+	commandsCtx, abortCommands = context.WithCancel(context.Background())
+	defer func() { commandsCtx, abortCommands = originalCtx, originalAbort }()
+
+	checker := NewStatusChecker("hang")
+	done := make(chan struct{})
+	go func() {
+		checker.performCheck()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	abortCommands()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("performCheck did not return after abortCommands")
+	}
+	if n := len(checker.results); n != 0 {
+		t.Errorf("Expected an aborted check not to be recorded, got %d results", n)
+	}
+}
+
+func TestMonitorStateRoundTrip(t *testing.T) {
+	originalFamilies := ipFamilies
+	ipFamilies = []string{familyIPv6}
+	defer func() { ipFamilies = originalFamilies }()
+
+	newMonitor := func() *Monitor {
+		monitor := NewMonitor(nil)
+		monitor.filter = &moduleFilter{}
+		monitor.start = nil
+		monitor.applyDiscovery([]ModuleInfo{{Name: "debian"}, {Name: "ubuntu"}})
+		return monitor
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	saved := newMonitor()
+	checkedAt := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	saved.checkers["debian"].results = []CheckResult{
+		{IsUp: true, HTTPStatus: 200, Timestamp: checkedAt},
+		{IsUp: false, HTTPStatus: 502, Error: "@ERROR: chroot failed", Category: "chroot_failed", Timestamp: checkedAt.Add(5 * time.Minute)},
+	}
+	saved.familyCheckers[familyIPv6]["ubuntu"].results = []CheckResult{{IsUp: false, HTTPStatus: 503, Timestamp: checkedAt}}
+	if err := saved.saveState(stateFile); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	restored := newMonitor()
+	// A check made since startup stays the most recent result.
+	restored.checkers["debian"].results = []CheckResult{{IsUp: true, HTTPStatus: 200, Timestamp: checkedAt.Add(time.Hour)}}
+	if err := restored.loadState(stateFile); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	got := restored.checkers["debian"].results
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 results for debian, got %d", len(got))
+	}
+	if got[1].Category != "chroot_failed" || !got[1].Timestamp.Equal(checkedAt.Add(5*time.Minute)) {
+		t.Errorf("Saved result not restored: %+v", got[1])
+	}
+	if !got[2].Timestamp.Equal(checkedAt.Add(time.Hour)) {
+		t.Errorf("Expected the newest check last, got %v", got[2].Timestamp)
+	}
+	if got := restored.familyCheckers[familyIPv6]["ubuntu"].results; len(got) != 1 || got[0].HTTPStatus != 503 {
+		t.Errorf("Expected ipv6 result restored, got %+v", got)
+	}
+
+	if err := newMonitor().loadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected a missing state file to be ignored, got %v", err)
+	}
+}
+
+func TestMonitorStateKeepsThroughput(t *testing.T) {
+	newMonitor := func() *Monitor {
+		monitor := NewMonitor(nil)
+		monitor.prober = NewThroughputProber("debian/ls-lR.gz")
+		return monitor
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	saved := newMonitor()
+	measuredAt := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	saved.prober.results = []ThroughputResult{
+		{Timestamp: measuredAt, Bytes: 1 << 20, BytesPerSec: 5e6},
+		{Timestamp: measuredAt.Add(time.Hour), Error: "timeout"},
+	}
+	if err := saved.saveState(stateFile); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	restored := newMonitor()
+	// A measurement made since startup stays the most recent one.
+	restored.prober.results = []ThroughputResult{{Timestamp: measuredAt.Add(2 * time.Hour), BytesPerSec: 6e6}}
+	if err := restored.loadState(stateFile); err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	got := restored.prober.results
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 throughput results, got %+v", got)
+	}
+	if got[0].BytesPerSec != 5e6 || !got[0].Timestamp.Equal(measuredAt) || got[1].Error != "timeout" {
+		t.Errorf("Saved throughput not restored: %+v", got[:2])
+	}
+	if got[2].BytesPerSec != 6e6 {
+		t.Errorf("Expected the newest measurement last, got %+v", got[2])
+	}
+
+	// Without BENCHMARK_FILE, saved measurements are ignored.
+	if err := NewMonitor(nil).loadState(stateFile); err != nil {
+		t.Errorf("loadState without a prober failed: %v", err)
+	}
+}
+
+// --- Probe tests ---
+
+func TestHealthz(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 4, 0, 1)
+
+	probe := func() (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		healthz(s)(rr, httptest.NewRequest("GET", "/healthz", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return rr.Code, body
+	}
+
+	if code, _ := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the loop runs, got %d", code)
+	}
+
+	s.tick()
+	code, body := probe()
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("Expected 200 ok after a tick, got %d %v", code, body)
+	}
+	scheduler := body["components"].(map[string]interface{})["scheduler"].(map[string]interface{})
+	if scheduler["ok"] != true || scheduler["last_tick"] == nil {
+		t.Errorf("Unexpected scheduler component: %v", scheduler)
+	}
+
+	clk.Advance(10 * time.Minute)
+	if code, _ := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a stuck loop, got %d", code)
+	}
+
+	s.tick()
+	s.Stop()
+	if code, _ := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once stopped, got %d", code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	originalStateFile := stateFile
+	defer func() { stateFile = originalStateFile }()
+	stateFile = ""
+
+	monitor := NewMonitor(nil)
+	monitor.filter = &moduleFilter{}
+	monitor.start = nil
+
+	probe := func() (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		readyz(monitor)(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return rr.Code, body["components"].(map[string]interface{})
+	}
+	okOf := func(components map[string]interface{}, name string) interface{} {
+		return components[name].(map[string]interface{})["ok"]
+	}
+
+	code, components := probe()
+	if code != http.StatusServiceUnavailable || okOf(components, "discovery") != false || okOf(components, "checks") != false {
+		t.Errorf("Expected not ready before discovery, got %d %v", code, components)
+	}
+
+	monitor.applyDiscovery([]ModuleInfo{{Name: "debian"}, {Name: "ubuntu"}})
+	if code, _ := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready before any check, got %d", code)
+	}
+
+	monitor.checkers["debian"].performCheck()
+	code, components = probe()
+	if code != http.StatusOK {
+		t.Errorf("Expected ready after a check, got %d %v", code, components)
+	}
+	if n := components["checks"].(map[string]interface{})["modules_checked"]; n != 1.0 {
+		t.Errorf("Expected 1 module checked, got %v", n)
+	}
+
+	// An unwritable state directory makes the monitor unready.
+	stateFile = filepath.Join(t.TempDir(), "missing-dir", "state.json")
+	code, components = probe()
+	if code != http.StatusServiceUnavailable || okOf(components, "storage") != false {
+		t.Errorf("Expected storage failure, got %d %v", code, components)
+	}
+	stateFile = filepath.Join(t.TempDir(), "state.json")
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("Expected ready with a writable state file, got %d", code)
+	}
+}
+
+// --- Module list refresh tests ---
+
+func TestModuleListingIncludesIgnored(t *testing.T) {
+	filter, err := parseModuleFilter("", "ubuntu", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitor(nil)
+	monitor.filter = filter
+	monitor.start = nil
+	monitor.applyDiscovery([]ModuleInfo{{Name: "debian"}, {Name: "ubuntu"}})
+
+	names, discoveredAt := monitor.moduleListing()
+	if strings.Join(names, ",") != "debian,ubuntu" {
+		t.Errorf("Expected the full listing, got %v", names)
+	}
+	if discoveredAt.IsZero() {
+		t.Error("Expected the discovery time to be recorded")
+	}
+}
+
+func TestRefreshIsRateLimited(t *testing.T) {
+	originalInterval := refreshMinInterval
+	refreshMinInterval = time.Minute
+	defer func() { refreshMinInterval = originalInterval }()
+
+	monitor := NewMonitor(nil)
+	monitor.filter = &moduleFilter{}
+	monitor.start = nil
+	handler := refreshHandler(monitor)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/refresh", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "POST" {
+		t.Errorf("Expected 405 with Allow: POST for GET, got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/refresh", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for the first refresh, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if dirs := fmt.Sprint(body["rsync_directories"]); dirs != "[debian ubuntu]" {
+		t.Errorf("Expected the refreshed listing, got %v", dirs)
+	}
+	if _, ok := monitor.checker("debian", ""); !ok {
+		t.Error("Expected refreshed modules to be monitored")
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/refresh", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 for an immediate second refresh, got %d", rr.Code)
+	}
+	if retry, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Expected Retry-After within the rate limit, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// Once the interval has passed, refreshing is allowed again.
+	if wait := monitor.reserveRefresh(time.Now().Add(time.Minute)); wait != 0 {
+		t.Errorf("Expected refresh to be allowed after the interval, got wait %v", wait)
+	}
+}
+
+func TestRefreshWaitsForServerSlot(t *testing.T) {
+	clk := &fakeClock{now: time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)}
+	s := NewScheduler(clk, 1, 0, 1)
+	monitor := NewMonitor(s)
+	monitor.filter = &moduleFilter{}
+	monitor.start = nil
+
+	// A scheduled job holds the daemon's only slot.
+	release, holding := make(chan struct{}), make(chan struct{})
+	go s.withSlot(serverOf(rsyncURL), func() {
+		close(holding)
+		<-release
+	})
+	<-holding
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		refreshHandler(monitor)(rr, httptest.NewRequest("POST", "/refresh", nil))
+		done <- rr.Code
+	}()
+	select {
+	case code := <-done:
+		t.Fatalf("Refresh finished with %d while the server's slot was taken", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("Expected 200 once the slot was free, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Refresh did not run after the slot was released")
+	}
+}
+
+func TestEventsStreamsCheckResults(t *testing.T) {
+	srv := httptest.NewServer(eventsHandler(events))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	// The handler has subscribed once its first comment arrives.
+	if line := <-lines; !strings.HasPrefix(line, ":") {
+		t.Fatalf("Expected a comment opening the stream, got %q", line)
+	}
+
+	NewStatusChecker("debian").performCheck()
+
+	// Other tests may publish too; look for this check.
+	timeout := time.After(10 * time.Second)
+	eventType := ""
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("Stream ended before the check was received")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				eventType = strings.TrimPrefix(line, "event: ")
+			}
+			if !strings.HasPrefix(line, "data: ") || eventType != eventCheck {
+				continue
+			}
+			var e struct {
+				Module string      `json:"module"`
+				Result CheckResult `json:"result"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("Invalid event data %q: %v", line, err)
+			}
+			if e.Module != "debian" {
+				continue
+			}
+			if !e.Result.IsUp || e.Result.Timestamp.IsZero() {
+				t.Errorf("Unexpected check result in event: %+v", e.Result)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for the check event")
+		}
+	}
+}
+
+func TestEventHubDropsSlowSubscribersAndCloses(t *testing.T) {
+	hub := newEventHub()
+	slow, _ := hub.subscribe()
+	fast, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= eventBuffer; i++ {
+		hub.publish(Event{Type: eventThroughput})
+		<-fast
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventBuffer {
+		t.Errorf("Expected the slow subscriber to get %d events before being dropped, got %d", eventBuffer, received)
+	}
+
+	srv := httptest.NewServer(eventsHandler(hub))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	bufio.NewReader(resp.Body).ReadString('\n')
+	hub.close()
+	if _, ok := <-fast; ok {
+		t.Error("Expected close to end every subscription")
+	}
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected close to end open streams")
+	}
+}
+
+func TestPerformCheckRecordsDuration(t *testing.T) {
+	checker := NewStatusChecker("slow")
+	checker.performCheck()
+	res, ok := checker.latest()
+	if !ok || !res.IsUp {
+		t.Fatalf("Expected a successful check, got %+v", res)
+	}
+	if res.DurationMs < 300 || res.DurationMs > 10000 {
+		t.Errorf("Expected the duration of the rsync run, got %dms", res.DurationMs)
+	}
+
+	rr := httptest.NewRecorder()
+	checker.ServeHTTP(rr, httptest.NewRequest("GET", "/status/slow", nil))
+	var body []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(body) != 1 || body[0]["duration_ms"] != float64(res.DurationMs) {
+		t.Errorf("Expected duration_ms %d in the status, got %v", res.DurationMs, body)
+	}
+}
+
+func TestStatusWindowAndResolution(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	checker := NewStatusChecker("debian")
+	checker.results = []CheckResult{{IsUp: true, HTTPStatus: http.StatusOK, Timestamp: now.Add(-48 * time.Hour)}}
+	for i := 0; i < 12; i++ {
+		res := CheckResult{IsUp: true, HTTPStatus: http.StatusOK, Message: "Operational", DurationMs: 100, Timestamp: now.Add(time.Duration(i-12) * 10 * time.Minute)}
+		if i == 8 {
+			res = CheckResult{IsUp: false, HTTPStatus: http.StatusBadGateway, Error: "connection reset", DurationMs: 900, Timestamp: res.Timestamp}
+		}
+		checker.results = append(checker.results, res)
+	}
+
+	get := func(query string) (int, []map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		checker.ServeHTTP(rr, httptest.NewRequest("GET", "/status/debian"+query, nil))
+		var body []map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body
+	}
+
+	if _, body := get(""); len(body) != 13 {
+		t.Errorf("Expected every result without a window, got %d", len(body))
+	}
+	if _, body := get("?window=1d"); len(body) != 12 {
+		t.Errorf("Expected the results of the last day, got %d", len(body))
+	}
+
+	// Hourly buckets: the checks of the first hour, then those of the second.
+	_, body := get("?window=1d&resolution=1h")
+	if len(body) != 2 {
+		t.Fatalf("Expected 2 hourly buckets, got %d: %v", len(body), body)
+	}
+	second := body[1]
+	if second["checks"] != float64(6) || second["failures"] != float64(1) {
+		t.Errorf("Expected 6 checks with 1 failure in the last hour, got %v", second)
+	}
+	if second["is_up"] != true || second["last_error"] != "connection reset" || second["duration_ms"] != float64(900) {
+		t.Errorf("Expected the bucket to end up, remember its failure and its longest check, got %v", second)
+	}
+	if body[0]["failures"] != float64(0) {
+		t.Errorf("Expected no failures in the first hour, got %v", body[0])
+	}
+
+	for _, query := range []string{"?window=soon", "?resolution=-5m", "?window=0h"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, code)
+		}
+	}
+}
+
+func TestParseSpan(t *testing.T) {
+	for spec, want := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "90m": 90 * time.Minute, "1h": time.Hour} {
+		if got, err := parseSpan(spec); err != nil || got != want {
+			t.Errorf("parseSpan(%q) = %v, %v; want %v", spec, got, err, want)
+		}
+	}
+	if _, err := parseSpan("xd"); err == nil {
+		t.Error("Expected an error for an invalid number of days")
+	}
+}
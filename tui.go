@@ -1,34 +1,405 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 // --- Configuration ---
-const apiBaseURL = "http://localhost:8080"
-const refreshInterval = 1 * time.Minute
+// Defaults, overridden in turn by the selected profile of the config file,
+// environment variables and command-line flags.
+const defaultAPIBaseURL = "http://localhost:8080"
+const defaultRefreshInterval = 1 * time.Minute
+// defaultSlowThreshold is how long a check may take before the module is
+// shown as degraded. Listing a module normally takes well under a second.
+const defaultSlowThreshold = 5 * time.Second
 // historyBarWidth agora é dinâmico, depende do tamanho do terminal
 
+// clientConfig is how the TUI reaches an rsyncuptime server.
+type clientConfig struct {
+	Profile  string        `json:"-"`
+	APIURL   string        `json:"api_url"`
+	Refresh  time.Duration `json:"-"`
+	Slow     time.Duration `json:"-"`         // checks taking longer are degraded
+	Token    string        `json:"token"`     // sent as "Authorization: Bearer <token>"
+	CAFile   string        `json:"ca_file"`   // PEM bundle used instead of the system roots
+	CertFile string        `json:"cert_file"` // client certificate, for mutual TLS
+	KeyFile  string        `json:"key_file"`
+	Insecure bool          `json:"insecure_skip_verify"`
+	Notify   string        `json:"notify"` // how module outages and recoveries are announced; see notifiers
+}
+
+// configFile is the client config file: named profiles and the ones used
+// when -profile is not given, e.g.
+//
+//	{"default": "prod", "profiles": {"prod": {"api_url": "https://...", "refresh": "30s", "slow": "10s"}}}
+type configFile struct {
+	Default  string                   `json:"default"` // one or more comma-separated profiles
+	Profiles map[string]profileConfig `json:"profiles"`
+}
+
+type profileConfig struct {
+	clientConfig
+	Refresh string `json:"refresh"` // a Go duration, e.g. "30s"
+	Slow    string `json:"slow"`    // a Go duration, e.g. "10s"
+}
+
+// defaultConfigPath is ~/.config/rsyncuptime/tui.json, or its equivalent.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rsyncuptime", "tui.json")
+}
+
+// name labels the endpoint in the TUI: its profile, or else its host.
+func (c clientConfig) name() string {
+	if c.Profile != "" {
+		return c.Profile
+	}
+	if u, err := url.Parse(c.APIURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return c.APIURL
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(spec string) []string {
+	var items []string
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfig builds the configuration of every watched endpoint from the
+// defaults, the config file, the environment and the command-line flags, in
+// that order. Several endpoints are watched when several profiles or API
+// URLs are given, comma-separated.
+func loadConfig() ([]clientConfig, error) {
+	configPath := flag.String("config", os.Getenv("RSYNCUPTIME_CONFIG"), "client config file with named profiles (env RSYNCUPTIME_CONFIG, default "+defaultConfigPath()+")")
+	profile := flag.String("profile", os.Getenv("RSYNCUPTIME_PROFILE"), "comma-separated profiles of the config file to watch (env RSYNCUPTIME_PROFILE)")
+	apiURL := flag.String("api-url", "", "comma-separated base URLs of rsyncuptime servers (env RSYNCUPTIME_API_URL)")
+	refresh := flag.Duration("refresh", 0, "refresh interval, e.g. 30s (env RSYNCUPTIME_REFRESH_SECONDS)")
+	slow := flag.Duration("slow", 0, "checks taking longer than this show as degraded, e.g. 10s (env RSYNCUPTIME_SLOW_SECONDS)")
+	token := flag.String("token", "", "bearer token sent to the server (env RSYNCUPTIME_TOKEN)")
+	caFile := flag.String("ca-file", "", "PEM CA bundle to verify the server (env RSYNCUPTIME_CA_FILE)")
+	certFile := flag.String("cert", "", "client certificate for mutual TLS (env RSYNCUPTIME_CERT_FILE)")
+	keyFile := flag.String("key", "", "client key for mutual TLS (env RSYNCUPTIME_KEY_FILE)")
+	insecure := flag.Bool("insecure", false, "skip verification of the server certificate (env RSYNCUPTIME_INSECURE)")
+	notify := flag.String("notify", "", "announce modules going down or back up with bell, osc9, osc777 or notify-send (env RSYNCUPTIME_NOTIFY)")
+	flag.Parse()
+
+	base := clientConfig{APIURL: defaultAPIBaseURL, Refresh: defaultRefreshInterval, Slow: defaultSlowThreshold}
+	endpoints := []clientConfig{base}
+
+	// A missing default config file is fine; one asked for explicitly is not.
+	path, explicit := *configPath, *configPath != "" || *profile != ""
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+		names := splitList(*profile)
+		if len(names) == 0 && file != nil {
+			names = splitList(file.Default)
+		}
+		if len(names) > 0 {
+			endpoints = nil
+		}
+		for _, name := range names {
+			cfg := base
+			if err := applyProfile(&cfg, file, path, name); err != nil {
+				return nil, err
+			}
+			endpoints = append(endpoints, cfg)
+		}
+	}
+
+	// Settings from the environment and flags apply to every endpoint. API
+	// URLs replace the endpoints, keeping the settings of the first one.
+	override := func(set func(*clientConfig)) {
+		for i := range endpoints {
+			set(&endpoints[i])
+		}
+	}
+	setURLs := func(spec string) {
+		template := endpoints[0]
+		template.Profile = ""
+		endpoints = nil
+		for _, u := range splitList(spec) {
+			cfg := template
+			cfg.APIURL = u
+			endpoints = append(endpoints, cfg)
+		}
+	}
+
+	if v := os.Getenv("RSYNCUPTIME_API_URL"); v != "" {
+		setURLs(v)
+	}
+	if v := os.Getenv("RSYNCUPTIME_REFRESH_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid RSYNCUPTIME_REFRESH_SECONDS value '%s'", v)
+		}
+		override(func(c *clientConfig) { c.Refresh = time.Duration(seconds) * time.Second })
+	}
+	if v := os.Getenv("RSYNCUPTIME_SLOW_SECONDS"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid RSYNCUPTIME_SLOW_SECONDS value '%s'", v)
+		}
+		override(func(c *clientConfig) { c.Slow = time.Duration(seconds * float64(time.Second)) })
+	}
+	if v := os.Getenv("RSYNCUPTIME_TOKEN"); v != "" {
+		override(func(c *clientConfig) { c.Token = v })
+	}
+	if v := os.Getenv("RSYNCUPTIME_CA_FILE"); v != "" {
+		override(func(c *clientConfig) { c.CAFile = v })
+	}
+	if v := os.Getenv("RSYNCUPTIME_CERT_FILE"); v != "" {
+		override(func(c *clientConfig) { c.CertFile = v })
+	}
+	if v := os.Getenv("RSYNCUPTIME_KEY_FILE"); v != "" {
+		override(func(c *clientConfig) { c.KeyFile = v })
+	}
+	if v := os.Getenv("RSYNCUPTIME_INSECURE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSYNCUPTIME_INSECURE value '%s'", v)
+		}
+		override(func(c *clientConfig) { c.Insecure = b })
+	}
+	if v := os.Getenv("RSYNCUPTIME_NOTIFY"); v != "" {
+		override(func(c *clientConfig) { c.Notify = v })
+	}
+
+	// Only flags actually given override the settings above.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "api-url":
+			setURLs(*apiURL)
+		case "refresh":
+			override(func(c *clientConfig) { c.Refresh = *refresh })
+		case "slow":
+			override(func(c *clientConfig) { c.Slow = *slow })
+		case "token":
+			override(func(c *clientConfig) { c.Token = *token })
+		case "ca-file":
+			override(func(c *clientConfig) { c.CAFile = *caFile })
+		case "cert":
+			override(func(c *clientConfig) { c.CertFile = *certFile })
+		case "key":
+			override(func(c *clientConfig) { c.KeyFile = *keyFile })
+		case "insecure":
+			override(func(c *clientConfig) { c.Insecure = *insecure })
+		case "notify":
+			override(func(c *clientConfig) { c.Notify = *notify })
+		}
+	})
+
+	if len(endpoints) == 0 {
+		return nil, errors.New("no API URL given")
+	}
+	for i := range endpoints {
+		cfg := &endpoints[i]
+		cfg.APIURL = strings.TrimSuffix(cfg.APIURL, "/")
+		if cfg.Refresh <= 0 {
+			return nil, fmt.Errorf("%s: refresh interval must be positive, got %v", cfg.name(), cfg.Refresh)
+		}
+		if cfg.Slow <= 0 {
+			return nil, fmt.Errorf("%s: slow threshold must be positive, got %v", cfg.name(), cfg.Slow)
+		}
+		if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+			return nil, fmt.Errorf("%s: client certificate and key must be given together", cfg.name())
+		}
+		if _, ok := notifiers[cfg.Notify]; !ok {
+			return nil, fmt.Errorf("%s: unknown notify method '%s' (use bell, osc9, osc777, notify-send or none)", cfg.name(), cfg.Notify)
+		}
+	}
+	return endpoints, nil
+}
+
+// readConfigFile loads and parses the client config file at path.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// applyProfile applies the named profile of file, read from path, to cfg.
+func applyProfile(cfg *clientConfig, file *configFile, path, name string) error {
+	if file == nil {
+		return fmt.Errorf("profile '%s' requested but %s could not be read", name, path)
+	}
+	p, ok := file.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile '%s' not found in %s", name, path)
+	}
+
+	cfg.Profile = name
+	if p.APIURL != "" {
+		cfg.APIURL = p.APIURL
+	}
+	if p.Refresh != "" {
+		d, err := time.ParseDuration(p.Refresh)
+		if err != nil {
+			return fmt.Errorf("profile '%s': invalid refresh '%s': %w", name, p.Refresh, err)
+		}
+		cfg.Refresh = d
+	}
+	if p.Slow != "" {
+		d, err := time.ParseDuration(p.Slow)
+		if err != nil {
+			return fmt.Errorf("profile '%s': invalid slow '%s': %w", name, p.Slow, err)
+		}
+		cfg.Slow = d
+	}
+	if p.Token != "" {
+		cfg.Token = p.Token
+	}
+	if p.CAFile != "" {
+		cfg.CAFile = p.CAFile
+	}
+	if p.CertFile != "" {
+		cfg.CertFile = p.CertFile
+		cfg.KeyFile = p.KeyFile
+	}
+	cfg.Insecure = cfg.Insecure || p.Insecure
+	if p.Notify != "" {
+		cfg.Notify = p.Notify
+	}
+	return nil
+}
+
+// apiClient talks to one rsyncuptime server.
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	stream  *http.Client // for /events, which must not time out
+}
+
+func newAPIClient(cfg clientConfig) (*apiClient, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &apiClient{
+		baseURL: cfg.APIURL,
+		token:   cfg.Token,
+		http:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		stream:  &http.Client{Transport: transport},
+	}, nil
+}
+
+// get requests path from the server, authenticating if a token is set.
+func (c *apiClient) get(path string) (*http.Response, error) {
+	req, err := c.newRequest(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+func (c *apiClient) newRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// openEvents connects to the server's /events stream. Servers too old to
+// have one answer 404, and the TUI keeps polling them.
+func (c *apiClient) openEvents() (*http.Response, error) {
+	req, err := c.newRequest("/events")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("event stream not available: %s", resp.Status)
+	}
+	return resp, nil
+}
+
 // --- Styles ---
 var (
 	statusUpStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))  // Green
 	statusDownStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("196")) // Red
 	statusPartialStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")) // Orange
+	statusDegradedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("220")) // Yellow
 	helpStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
 	moduleNameStyle    = lipgloss.NewStyle().Bold(true).Width(20)
 	errorMsgStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Italic(true)
+	selectedStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Bold(true)
+	filterMatchStyle   = lipgloss.NewStyle().Underline(true)
+	sectionStyle       = lipgloss.NewStyle().Bold(true).Underline(true)
+	staleStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+	noDataStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("238"))
 )
 
 // --- API Data Structures ---
@@ -36,136 +407,1135 @@ var (
 type CheckResult struct {
 	   IsUp          bool      `json:"is_up"`
 	   Message       string    `json:"message"`
+	   Error         string    `json:"error,omitempty"`
+	   HTTPStatus    int       `json:"http_status"`
+	   Category      string    `json:"category,omitempty"`
 	   RsyncExitCode int       `json:"rsync_exit_code,omitempty"`
 	   RsyncOutput   string    `json:"rsync_output,omitempty"`
 	   Timestamp     time.Time `json:"timestamp"`
+	   DurationMs    int64     `json:"duration_ms"` // 0 from servers that do not time checks
+	   // Set when the server summarizes a time bucket of checks into one result.
+	   Checks    int    `json:"checks,omitempty"`
+	   Failures  int    `json:"failures,omitempty"`
+	   LastError string `json:"last_error,omitempty"`
+}
+
+// counts is how many checks a result stands for and how many of them
+// failed: one, unless the server summarized a time bucket into it.
+func (c CheckResult) counts() (checks, failures int) {
+	if c.Checks > 0 {
+		return c.Checks, c.Failures
+	}
+	if c.IsUp {
+		return 1, 0
+	}
+	return 1, 1
+}
+
+// ThroughputResult is one measurement from the server's /throughput endpoint.
+type ThroughputResult struct {
+	Timestamp   time.Time `json:"timestamp"`
+	BytesPerSec float64   `json:"bytes_per_sec"`
+	Error       string    `json:"error,omitempty"`
 }
 
 // --- Bubble Tea Messages ---
+
+// serverUpdate is everything fetched from one server, or why it failed.
+type serverUpdate struct {
+	index        int // of the server in model.servers
+	statuses     map[string][]CheckResult
+	descriptions map[string]string
+	throughput   []ThroughputResult
+	// pollingInterval is how often the server checks each module.
+	pollingInterval time.Duration
+	window          historyWindow // the history was fetched for
+	err             error
+}
+
+// statusUpdateMsg carries one update per fetched server.
 type statusUpdateMsg struct {
-	statuses map[string][]CheckResult
+	updates []serverUpdate
+}
+
+// tickMsg asks for the servers without a live event stream to be polled.
+type tickMsg struct{}
+
+// streamEvent is one event read from a server's /events stream, or the
+// error that ended the stream.
+type streamEvent struct {
+	kind string
+	data []byte
+	err  error
+}
+
+// streamOpenedMsg reports a connected event stream; events arrive on events.
+type streamOpenedMsg struct {
+	server int
+	events <-chan streamEvent
+}
+
+// streamEventMsg carries a result pushed by a server.
+type streamEventMsg struct {
+	server int
+	event  streamEvent
+	events <-chan streamEvent // to wait for the next one
+}
+
+// streamClosedMsg reports that a server's event stream could not be opened
+// or ended. The server is polled until it can be opened again.
+type streamClosedMsg struct {
+	server int
+	err    error
+}
+
+// server is one watched rsyncuptime API and the latest data fetched from it.
+type server struct {
+	name         string
+	profile      string
+	client       *apiClient
+	statuses     map[string][]CheckResult
+	descriptions map[string]string // comentário de cada módulo no rsyncd.conf
+	throughput   []ThroughputResult
+	slow         time.Duration // checks taking longer are degraded
+	checkEvery   time.Duration // the server's polling interval; 0 if unknown
+	err          error     // of the last fetch; the data fetched before is kept
+	updatedAt    time.Time // of the last successful fetch
+	collapsed    bool
+	live         bool // results are pushed through /events instead of polled
+	subscribing  bool // an attempt to open /events is in progress
+	notify       string // how its modules' transitions are announced
+}
+
+func newServer(cfg clientConfig, client *apiClient) *server {
+	return &server{name: cfg.name(), profile: cfg.Profile, client: client, slow: cfg.Slow, notify: cfg.Notify}
+}
+
+// apply records a fetch. A failed fetch keeps the previous data, which the
+// TUI shows as stale rather than as modules being down.
+func (s *server) apply(u serverUpdate) {
+	s.err = u.err
+	if u.err != nil {
+		return
+	}
+	s.statuses = u.statuses
+	s.descriptions = u.descriptions
+	s.throughput = u.throughput
+	s.checkEvery = u.pollingInterval
+	s.updatedAt = time.Now()
+}
+
+// historyWindow is a span of history the TUI can show, switched with "w".
+type historyWindow struct {
+	name string
+	span time.Duration
+}
+
+var historyWindows = []historyWindow{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// findWindow returns the history window named name, e.g. "7d".
+func findWindow(name string) (historyWindow, bool) {
+	for _, w := range historyWindows {
+		if w.name == name {
+			return w, true
+		}
+	}
+	return historyWindow{}, false
+}
+
+// historyBuckets is how many time buckets the server is asked to summarize
+// a window into: more than the widest chart has cells, so that a 30 day
+// window stays small without blurring the bars.
+const historyBuckets = 720
+
+// resolution is the length of the buckets the server summarizes w into.
+func (w historyWindow) resolution() time.Duration {
+	return w.span / historyBuckets
+}
+
+// applyEvent records a result pushed through the event stream, keeping the
+// history within window.
+func (s *server) applyEvent(e streamEvent, window time.Duration) error {
+	switch e.kind {
+	case "check":
+		var event struct {
+			Module string      `json:"module"`
+			Family string      `json:"family"`
+			Result CheckResult `json:"result"`
+		}
+		if err := json.Unmarshal(e.data, &event); err != nil {
+			return fmt.Errorf("bad check event: %w", err)
+		}
+		// Only the default checks are shown, not the per-family ones.
+		if event.Family != "" {
+			return nil
+		}
+		if s.statuses == nil {
+			s.statuses = make(map[string][]CheckResult)
+		}
+		history := append(s.statuses[event.Module], event.Result)
+		for len(history) > 1 && event.Result.Timestamp.Sub(history[0].Timestamp) > window {
+			history = history[1:]
+		}
+		s.statuses[event.Module] = history
+	case "throughput":
+		var event struct {
+			Result ThroughputResult `json:"result"`
+		}
+		if err := json.Unmarshal(e.data, &event); err != nil {
+			return fmt.Errorf("bad throughput event: %w", err)
+		}
+		throughput := append(s.throughput, event.Result)
+		for len(throughput) > 1 && event.Result.Timestamp.Sub(throughput[0].Timestamp) > window {
+			throughput = throughput[1:]
+		}
+		s.throughput = throughput
+	}
+	return nil
+}
+
+// moduleKey identifies a module of one of the watched servers.
+type moduleKey struct {
+	server int
+	name   string
 }
-type errMsg struct{ err error }
 
 // --- Bubble Tea Model ---
 type model struct {
-	   statuses   map[string][]CheckResult
-	   err        error
+	   servers    []*server
 	   quitting   bool
-	   ticker     *time.Ticker
+	   refresh    time.Duration // intervalo de polling dos servidores sem /events
 	   width      int // largura do terminal
+	   height     int // altura do terminal
 	   refreshing bool // indica se o botão de refresh está ativo
+	   list        list.Model     // visão geral: um módulo por linha
+	   sortMode    sortMode
+	   failingOnly bool           // mostra só os módulos fora do ar
+	   detail      *moduleKey     // módulo aberto na visão de detalhe, nil na visão geral
+	   showLegend  bool           // legenda de cores e estados, alternada com "?"
+	   hover       int            // célula do gráfico apontada na visão de detalhe, -1 se nenhuma
+	   viewport    viewport.Model // conteúdo rolável da visão de detalhe
+	   window      historyWindow  // período mostrado, alternado com "w" ou 1-4
+	   notifications     []transition // módulos que caíram ou voltaram, do mais antigo ao mais recente
+	   showNotifications bool         // painel de notificações, alternado com "n"
+	   notifyErr         error        // da última notificação que não pôde ser enviada
 }
 
-func initialModel() model {
+func initialModel(servers []*server, refresh time.Duration) model {
+	   modules := list.New(nil, moduleDelegate{}, 80, 20)
+	   modules.SetShowTitle(false)
+	   modules.SetShowStatusBar(false)
+	   modules.SetShowHelp(false)
+	   modules.SetStatusBarItemName("module", "modules")
+	   modules.DisableQuitKeybindings()
+	   // Keep the chosen sort order while filtering.
+	   modules.Filter = list.UnsortedFilter
+	   // "f" toggles the failing-only view instead of turning the page.
+	   modules.KeyMap.NextPage = key.NewBinding(key.WithKeys("right", "l", "pgdown", "d"), key.WithHelp("→/l/pgdn", "next page"))
+
 	   return model{
-			   statuses:   make(map[string][]CheckResult),
-			   ticker:     time.NewTicker(refreshInterval),
+			   servers:    servers,
+			   refresh:    refresh,
 			   width:      80, // valor padrão inicial
+			   height:     24,
 			   refreshing: false,
+			   list:       modules,
+			   hover:      -1,
+			   viewport:   viewport.New(80, 24-detailChromeLines),
+			   window:     historyWindows[1],
 	   }
 }
 
-// --- Bubble Tea Commands ---
-func fetchStatuses() tea.Cmd {
-	return func() tea.Msg {
-		resp, err := http.Get(apiBaseURL + "/")
-		if err != nil {
-			return errMsg{err}
+// item is module name of the server, the index-th one watched, as listed
+// with a window of history.
+func (s *server) item(index int, name string, window time.Duration) moduleItem {
+	history := s.statuses[name]
+	return moduleItem{
+		server:      index,
+		name:        name,
+		description: s.descriptions[name],
+		history:     history,
+		stale:       s.err != nil,
+		slow:        s.slow,
+		checkEvery:  s.checkEvery,
+		late:        isLate(history, s.checkEvery, time.Now()),
+		window:      window,
+	}
+}
+
+// moduleItem is a module as listed in the overview.
+type moduleItem struct {
+	server      int
+	name        string
+	description string
+	history     []CheckResult
+	stale       bool          // its server could not be reached on the last fetch
+	slow        time.Duration // checks taking longer are degraded
+	checkEvery  time.Duration // the server's polling interval; 0 if unknown
+	late        bool          // not checked for over two polling intervals
+	window      time.Duration // of history shown by the bar
+}
+
+func (i moduleItem) FilterValue() string { return i.name }
+
+// degraded reports a module that is up but whose last check was slow, or
+// that has not been checked for a while.
+func (i moduleItem) degraded() bool {
+	if len(i.history) == 0 || i.failing() {
+		return false
+	}
+	return i.late || isSlow(i.history[len(i.history)-1], i.slow)
+}
+
+// isSlow reports a successful check that took longer than slow.
+func isSlow(check CheckResult, slow time.Duration) bool {
+	return check.IsUp && time.Duration(check.DurationMs)*time.Millisecond > slow
+}
+
+// isLate reports whether history has no check for over two of the server's
+// polling intervals, e.g. because checks are stuck.
+func isLate(history []CheckResult, checkEvery time.Duration, now time.Time) bool {
+	if len(history) == 0 || checkEvery <= 0 {
+		return false
+	}
+	return now.Sub(history[len(history)-1].Timestamp) > 2*checkEvery
+}
+
+// serverItem heads the modules of a server when several are watched.
+type serverItem struct {
+	index   int
+	srv     *server
+	modules int // shown under it, after the failing-only toggle
+	down    int
+}
+
+func (i serverItem) FilterValue() string { return i.srv.name }
+
+// itemKey identifies a list item; server headers have an empty name.
+func itemKey(item list.Item) (moduleKey, bool) {
+	switch item := item.(type) {
+	case moduleItem:
+		return moduleKey{item.server, item.name}, true
+	case serverItem:
+		return moduleKey{server: item.index}, true
+	}
+	return moduleKey{}, false
+}
+
+// failing reports whether the latest check of the module failed.
+func (i moduleItem) failing() bool {
+	return len(i.history) > 0 && !i.history[len(i.history)-1].IsUp
+}
+
+// uptime is the percentage of successful checks in the history.
+func (i moduleItem) uptime() float64 {
+	up, total := uptime(i.history)
+	if total == 0 {
+		return 0
+	}
+	return float64(up) / float64(total) * 100.0
+}
+
+// uptime counts the successful checks of history, and all of them.
+func uptime(history []CheckResult) (up, total int) {
+	for _, check := range history {
+		checks, failures := check.counts()
+		up += checks - failures
+		total += checks
+	}
+	return up, total
+}
+
+// lastFailure is the time of the most recent failed check, or zero.
+func (i moduleItem) lastFailure() time.Time {
+	for j := len(i.history) - 1; j >= 0; j-- {
+		if _, failures := i.history[j].counts(); failures > 0 {
+			return i.history[j].Timestamp
 		}
-		defer resp.Body.Close()
+	}
+	return time.Time{}
+}
+
+// sortMode is the order of the overview list, cycled with "s".
+type sortMode int
 
-		var discoveryResponse struct {
-			Modules map[string]string `json:"monitored_modules"`
+const (
+	sortByName sortMode = iota
+	sortByUptime
+	sortByStatus
+	sortByLastFailure
+)
+
+var sortModeNames = []string{"name", "uptime", "status", "last failure"}
+
+// sortItems orders items by mode, falling back to the module name.
+func sortItems(items []moduleItem, mode sortMode) {
+	sort.Slice(items, func(a, b int) bool { return items[a].name < items[b].name })
+	// Outages first, then modules that failed recently, then healthy ones.
+	statusRank := func(i moduleItem) int {
+		switch {
+		case i.failing():
+			return 0
+		case !i.lastFailure().IsZero():
+			return 1
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&discoveryResponse); err != nil {
-			return errMsg{err}
+		return 2
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		switch mode {
+		case sortByUptime:
+			return items[a].uptime() < items[b].uptime()
+		case sortByStatus:
+			return statusRank(items[a]) < statusRank(items[b])
+		case sortByLastFailure:
+			return items[a].lastFailure().After(items[b].lastFailure())
 		}
+		return false
+	})
+}
 
-		statuses := make(map[string][]CheckResult)
-		var mu sync.Mutex
-		var wg sync.WaitGroup
+// refreshItems rebuilds the overview list from the latest statuses, keeping
+// the selected item selected. With several servers, each one's modules are
+// grouped under a header and can be collapsed.
+func (m *model) refreshItems() tea.Cmd {
+	selected, hasSelection := itemKey(m.list.SelectedItem())
+
+	var listItems []list.Item
+	for i, srv := range m.servers {
+		var items []moduleItem
+		down := 0
+		for name := range srv.statuses {
+			item := srv.item(i, name, m.window.span)
+			if item.failing() {
+				down++
+			}
+			if m.failingOnly && !item.failing() {
+				continue
+			}
+			items = append(items, item)
+		}
+		sortItems(items, m.sortMode)
+
+		if len(m.servers) > 1 {
+			listItems = append(listItems, serverItem{index: i, srv: srv, modules: len(items), down: down})
+			if srv.collapsed {
+				continue
+			}
+		}
+		for _, item := range items {
+			listItems = append(listItems, item)
+		}
+	}
+	cmd := m.list.SetItems(listItems)
+	if hasSelection {
+		for i, item := range m.list.VisibleItems() {
+			if key, _ := itemKey(item); key == selected {
+				m.list.Select(i)
+				break
+			}
+		}
+	}
+	return cmd
+}
+
+// headerLines is how many lines the overview shows above the list.
+func (m model) headerLines() int {
+	lines := 2 // title and axis
+	if len(m.servers) == 1 && len(m.servers[0].throughput) > 0 {
+		lines++
+	}
+	return lines
+}
+
+// resize fits the list and the detail viewport to the terminal.
+func (m *model) resize() {
+	footer := 1
+	if m.showLegend {
+		footer += legendLines
+	}
+	if m.showNotifications {
+		footer += notificationLines
+	}
+	m.list.SetSize(m.width, max(m.height-m.headerLines()-footer, 1))
+	m.viewport.Width = m.width
+	m.viewport.Height = max(m.height-detailChromeLines, 1)
+}
+
+// barColumn is where the history bar starts in a module row, after the
+// cursor (2), the name (20) and the uptime (17), each followed by a space.
+const barColumn = 2 + 20 + 1 + 17 + 1
+
+// barWidth is the width of the history bar for a terminal width.
+func barWidth(width int) int {
+	   // Defina a largura mínima e máxima do historyBar
+	   // Reservar espaço para cursor (2), nome (20), uptime (17), latência, status (12), margem (3)
+	   minBarWidth := 10
+	   reserved := 2 + 20 + 17 + latencyColumns + 12 + 3
+	   barWidth := width - reserved
+	   if barWidth < minBarWidth {
+			   barWidth = minBarWidth
+	   } else if barWidth > 120 {
+			   barWidth = 120
+	   }
+	   return barWidth
+}
+
+// moduleDelegate renders one line of the overview list per module.
+type moduleDelegate struct{}
+
+func (d moduleDelegate) Height() int                             { return 1 }
+func (d moduleDelegate) Spacing() int                            { return 0 }
+func (d moduleDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
+
+func (d moduleDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	var row string
+	switch item := listItem.(type) {
+	case serverItem:
+		row = renderServerHeader(item, barWidth(m.Width()), index == m.Index())
+	case moduleItem:
+		// Highlight the letters matched by the filter.
+		name := lipgloss.StyleRunes(item.name, m.MatchesForItem(index), filterMatchStyle, lipgloss.NewStyle())
+		row = renderModuleRow(item, name, barWidth(m.Width()), index == m.Index())
+	}
+	fmt.Fprint(w, ansi.Truncate(row, m.Width(), "…"))
+}
+
+// renderServerHeader shows a server's name, URL and connection status, so an
+// unreachable monitor is not mistaken for modules being down.
+func renderServerHeader(item serverItem, barWidth int, selected bool) string {
+	srv := item.srv
+	marker := "  "
+	if selected {
+		marker = selectedStyle.Render("▸ ")
+	}
+	fold := "▾ "
+	if srv.collapsed {
+		fold = "▹ "
+	}
+
+	var status string
+	switch {
+	case srv.err != nil:
+		status = statusDownStyle.Render("● unreachable") + errorMsgStyle.Render(" "+strings.SplitN(srv.err.Error(), "\n", 2)[0])
+		if !srv.updatedAt.IsZero() {
+			status += errorMsgStyle.Render(fmt.Sprintf(" (data from %s)", srv.updatedAt.Format("15:04:05")))
+		}
+	case srv.updatedAt.IsZero():
+		status = helpStyle.Render("○ connecting…")
+	default:
+		status = statusUpStyle.Render("● connected")
+		summary := " · polling"
+		if srv.live {
+			summary = " · live"
+		}
+		summary += fmt.Sprintf(" · %d modules", len(srv.statuses))
+		if item.down > 0 {
+			summary += fmt.Sprintf(", %d down", item.down)
+		}
+		status += helpStyle.Render(summary)
+	}
+	header := marker + sectionStyle.Render(fold+srv.name) + helpStyle.Render(" "+srv.client.baseURL+"  ") + status
+	if len(srv.throughput) > 0 {
+		header += "  " + renderThroughput(srv.throughput, min(barWidth, 24))
+	}
+	return header
+}
+
+// MODIFIED: Shows the specific error message for outages.
+func renderModuleRow(item moduleItem, name string, barWidth int, selected bool) string {
+	   history := item.history
+	   bar := renderHistoryBar(history, newTimeline(time.Now(), item.window, barWidth), item.slow, item.checkEvery)
+	   latency := renderLatency(history, latencyWidth, item.slow)
+	   latestResult := CheckResult{IsUp: true, Message: "Operational"}
+	   if len(history) > 0 {
+			   latestResult = history[len(history)-1]
+	   }
+
+	   var statusText string
+	   var errorDetails string
+	   if item.stale {
+			   // The monitor is unreachable: the data is old, not necessarily bad.
+			   statusText = staleStyle.Render("Unknown")
+			   errorDetails = errorMsgStyle.Render(" (monitor unreachable)")
+	   } else if !latestResult.IsUp {
+			   statusText = statusDownStyle.Render("Outage")
+			   // Simplifica: mostra só código rsync e primeira linha do erro
+			   var details string
+			   if latestResult.RsyncExitCode != 0 {
+					   details += fmt.Sprintf("Código rsync: %d. ", latestResult.RsyncExitCode)
+			   }
+			   var firstLine string
+			   if latestResult.RsyncOutput != "" {
+					   firstLine = strings.SplitN(latestResult.RsyncOutput, "\n", 2)[0]
+			   } else if latestResult.Message != "" {
+					   firstLine = strings.SplitN(latestResult.Message, "\n", 2)[0]
+			   }
+			   if details != "" || firstLine != "" {
+					   errorDetails = errorMsgStyle.Render(" Erro: " + details + firstLine)
+			   }
+	   } else if item.degraded() {
+			   statusText = statusDegradedStyle.Render("Degraded")
+			   if item.late {
+					   errorDetails = errorMsgStyle.Render(fmt.Sprintf(" (no check for %s)", time.Since(latestResult.Timestamp).Round(time.Minute)))
+			   } else {
+					   errorDetails = errorMsgStyle.Render(" (slow check: " + formatLatency(latestResult.DurationMs) + ")")
+			   }
+	   } else if strings.Contains(bar, "196") {
+			   statusText = statusPartialStyle.Render("Partial Outage")
+			   errorDetails = errorMsgStyle.Render(" (Recent recovery)")
+	   } else {
+			   statusText = statusUpStyle.Render("Operational")
+			   if item.description != "" {
+					   errorDetails = errorMsgStyle.Render(" " + item.description)
+			   }
+	   }
 
-		for name := range discoveryResponse.Modules {
+	   // Exibe o nome, uptime, barra, status e detalhes na mesma linha
+	   rawUptime := fmt.Sprintf("%.2f %%", item.uptime())
+	   paddedUptime := fmt.Sprintf("%-10s uptime", rawUptime)
+	   uptimeStr := helpStyle.Render(paddedUptime)
+	   marker := "  "
+	   if selected {
+			   marker = selectedStyle.Render("▸ ")
+	   }
+	   return fmt.Sprintf("%s%s %s %s %s %s%s", marker, moduleNameStyle.Render(name), uptimeStr, bar, latency, statusText, errorDetails)
+}
+
+// --- Bubble Tea Commands ---
+
+// fetch fetches servers concurrently: every one if all is set, otherwise
+// only those without a live event stream.
+func (m model) fetch(all bool) tea.Cmd {
+	var indices []int
+	for i, srv := range m.servers {
+		if all || !srv.live {
+			indices = append(indices, i)
+		}
+	}
+	return fetchServers(m.servers, m.window, indices...)
+}
+
+// fetchServers fetches the window of history of the servers at indices
+// concurrently.
+func fetchServers(servers []*server, window historyWindow, indices ...int) tea.Cmd {
+	if len(indices) == 0 {
+		return nil
+	}
+	// The servers are only read and written by Update; hand out the clients.
+	clients := make([]*apiClient, len(indices))
+	for i, index := range indices {
+		clients[i] = servers[index].client
+	}
+	return func() tea.Msg {
+		updates := make([]serverUpdate, len(clients))
+		var wg sync.WaitGroup
+		for i, c := range clients {
 			wg.Add(1)
-			go func(moduleName string) {
+			go func() {
 				defer wg.Done()
-				history, err := fetchModuleHistory(moduleName)
-				mu.Lock()
-				if err != nil {
-					statuses[moduleName] = []CheckResult{{IsUp: false, Message: err.Error()}}
-				} else {
-					statuses[moduleName] = history
-				}
-				mu.Unlock()
-			}(name)
+				updates[i] = fetchServer(c, window)
+				updates[i].index = indices[i]
+				updates[i].window = window
+			}()
 		}
 		wg.Wait()
+		return statusUpdateMsg{updates: updates}
+	}
+}
+
+// fetchServer fetches the module list of a server and the history of each module.
+func fetchServer(c *apiClient, window historyWindow) serverUpdate {
+	resp, err := c.get("/")
+	if err != nil {
+		return serverUpdate{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return serverUpdate{err: fmt.Errorf("api returned %s", resp.Status)}
+	}
+
+	var discoveryResponse struct {
+		Modules map[string]struct {
+			Endpoint    string `json:"endpoint"`
+			Description string `json:"description"`
+		} `json:"monitored_modules"`
+		PollingInterval float64 `json:"polling_interval_s"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discoveryResponse); err != nil {
+		return serverUpdate{err: err}
+	}
+
+	statuses := make(map[string][]CheckResult)
+	descriptions := make(map[string]string)
+	for name, module := range discoveryResponse.Modules {
+		descriptions[name] = module.Description
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name := range discoveryResponse.Modules {
+		wg.Add(1)
+		go func(moduleName string) {
+			defer wg.Done()
+			history, err := fetchModuleHistory(c, moduleName, window)
+			mu.Lock()
+			if err != nil {
+				statuses[moduleName] = []CheckResult{{IsUp: false, Message: err.Error()}}
+			} else {
+				statuses[moduleName] = history
+			}
+			mu.Unlock()
+		}(name)
+	}
+	var throughput []ThroughputResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The throughput probe is optional; ignore errors when it is disabled.
+		throughput, _ = fetchThroughput(c)
+	}()
+	wg.Wait()
+
+	return serverUpdate{
+		statuses:        statuses,
+		descriptions:    descriptions,
+		throughput:      throughput,
+		pollingInterval: time.Duration(discoveryResponse.PollingInterval * float64(time.Second)),
+	}
+}
+
+func fetchThroughput(c *apiClient) ([]ThroughputResult, error) {
+	resp, err := c.get("/throughput")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("throughput not available: %s", resp.Status)
+	}
+
+	var results []ThroughputResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("bad json from api for throughput: %w", err)
+	}
+	return results, nil
+}
+
+// fetchModuleHistory fetches the history of a module over window, summarized
+// by the server into buckets. Servers without summaries ignore the query and
+// return their whole history.
+func fetchModuleHistory(c *apiClient, name string, window historyWindow) ([]CheckResult, error) {
+	query := url.Values{"window": {window.name}, "resolution": {window.resolution().String()}}
+	resp, err := c.get("/status/" + name + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []CheckResult
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, fmt.Errorf("bad json from api for %s: %w", name, err)
+	}
+	return history, nil
+}
+
+// Command to wait for the next tick.
+func (m model) waitForTick() tea.Cmd {
+	return tea.Tick(m.refresh, func(time.Time) tea.Msg { return tickMsg{} })
+}
+
+// subscribe opens the event streams of the servers that have none, so
+// results are pushed as they happen instead of polled.
+func (m model) subscribe() tea.Cmd {
+	var cmds []tea.Cmd
+	for i, srv := range m.servers {
+		if srv.live || srv.subscribing {
+			continue
+		}
+		srv.subscribing = true
+		cmds = append(cmds, openStream(i, srv.client))
+	}
+	return tea.Batch(cmds...)
+}
+
+// streamIdleTimeout is how long an event stream may stay silent before it
+// is considered dead. The server sends a keepalive every 15 seconds.
+const streamIdleTimeout = 45 * time.Second
+
+func openStream(index int, c *apiClient) tea.Cmd {
+	return func() tea.Msg {
+		resp, err := c.openEvents()
+		if err != nil {
+			return streamClosedMsg{server: index, err: err}
+		}
+		events := make(chan streamEvent)
+		go readEvents(resp.Body, events)
+		return streamOpenedMsg{server: index, events: events}
+	}
+}
+
+// nextEvent waits for the next event of a server's stream.
+func nextEvent(index int, events <-chan streamEvent) tea.Cmd {
+	return func() tea.Msg {
+		event := <-events
+		if event.err != nil {
+			return streamClosedMsg{server: index, err: event.err}
+		}
+		return streamEventMsg{server: index, event: event, events: events}
+	}
+}
+
+// readEvents parses a Server-Sent Events stream into events, ending with
+// one carrying the error that closed it.
+func readEvents(body io.ReadCloser, events chan<- streamEvent) {
+	defer body.Close()
+	// Closing the body unblocks the scanner when keepalives stop arriving.
+	idle := time.AfterFunc(streamIdleTimeout, func() { body.Close() })
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(body)
+	// Check results carry the full rsync output.
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var event streamEvent
+	for scanner.Scan() {
+		idle.Reset(streamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.data != nil {
+				events <- event
+			}
+			event = streamEvent{}
+		case strings.HasPrefix(line, "event:"):
+			event.kind = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.data = append(event.data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("event stream closed by the server")
+	}
+	events <- streamEvent{err: err}
+}
+
+// --- Notifications ---
+
+// transition is a module going down or coming back up.
+type transition struct {
+	at     time.Time // of the check that found it
+	server string
+	module string
+	up     bool
+	err    string // why the check failed, when going down
+}
+
+// moduleStates tells whether the latest check of each module passed.
+// Modules whose history could not be fetched are left out.
+func (s *server) moduleStates() map[string]bool {
+	states := make(map[string]bool, len(s.statuses))
+	for name, history := range s.statuses {
+		if len(history) > 0 && !history[len(history)-1].Timestamp.IsZero() {
+			states[name] = history[len(history)-1].IsUp
+		}
+	}
+	return states
+}
+
+// transitions lists the modules whose state changed since before. Modules
+// that were not known before, e.g. on the first fetch, are not transitions.
+func (s *server) transitions(before map[string]bool) []transition {
+	var changed []transition
+	for name, up := range s.moduleStates() {
+		if was, ok := before[name]; !ok || was == up {
+			continue
+		}
+		latest := s.statuses[name][len(s.statuses[name])-1]
+		t := transition{at: latest.Timestamp, server: s.name, module: name, up: up}
+		if !up {
+			t.err = checkError(latest)
+		}
+		changed = append(changed, t)
+	}
+	sort.Slice(changed, func(a, b int) bool { return changed[a].module < changed[b].module })
+	return changed
+}
+
+// notificationLogSize is how many transitions the notification log keeps.
+const notificationLogSize = 50
+
+// record logs the transitions of srv since before, and announces them the
+// way the server is configured to.
+func (m *model) record(srv *server, before map[string]bool) tea.Cmd {
+	changed := srv.transitions(before)
+	if len(changed) == 0 {
+		return nil
+	}
+	m.notifications = append(m.notifications, changed...)
+	if n := len(m.notifications); n > notificationLogSize {
+		m.notifications = m.notifications[n-notificationLogSize:]
+	}
+	return notifiers[srv.notify](notificationText(changed, len(m.servers) > 1))
+}
+
+// notificationText summarizes transitions found together in one message,
+// so that a whole mirror going down is a single notification.
+func notificationText(changed []transition, withServer bool) (title, body string) {
+	where := func(t transition) string {
+		if withServer {
+			return t.module + " @ " + t.server
+		}
+		return t.module
+	}
+	if len(changed) == 1 {
+		t := changed[0]
+		if t.up {
+			return where(t) + " is back up", ""
+		}
+		return where(t) + " is down", t.err
+	}
+	var down, up []string
+	for _, t := range changed {
+		if t.up {
+			up = append(up, where(t))
+		} else {
+			down = append(down, where(t))
+		}
+	}
+	var parts []string
+	if len(down) > 0 {
+		parts = append(parts, fmt.Sprintf("%d modules down", len(down)))
+	}
+	if len(up) > 0 {
+		parts = append(parts, fmt.Sprintf("%d back up", len(up)))
+	}
+	title = strings.Join(parts, ", ")
+	if len(down) > 0 {
+		body = "Down: " + strings.Join(down, ", ")
+	}
+	if len(up) > 0 {
+		body = strings.TrimSpace(body + "\nUp: " + strings.Join(up, ", "))
+	}
+	return title, body
+}
+
+// notifiers announce transitions, by the name used in the notify setting.
+// They write to the terminal between frames, or run notify-send in the
+// background.
+var notifiers = map[string]func(title, body string) tea.Cmd{
+	"":     func(string, string) tea.Cmd { return nil },
+	"none": func(string, string) tea.Cmd { return nil },
+	"bell": func(string, string) tea.Cmd { return writeTerminal("\a") },
+	// iTerm2, WezTerm, kitty, foot and Windows Terminal.
+	"osc9": func(title, body string) tea.Cmd {
+		if body != "" {
+			title += ": " + body
+		}
+		return writeTerminal(osc("9;" + oscText(title)))
+	},
+	// rxvt-unicode, Konsole, VTE based terminals such as GNOME Terminal.
+	"osc777": func(title, body string) tea.Cmd {
+		return writeTerminal(osc("777;notify;" + oscText("rsyncuptime: "+title) + ";" + oscText(body)))
+	},
+	"notify-send": func(title, body string) tea.Cmd {
+		return func() tea.Msg {
+			out, err := exec.Command("notify-send", "--app-name=rsyncuptime", title, body).CombinedOutput()
+			if err != nil {
+				return notifyFailedMsg{fmt.Errorf("notify-send: %w %s", err, strings.TrimSpace(string(out)))}
+			}
+			return nil
+		}
+	},
+}
+
+// notifyFailedMsg reports a notification that could not be sent.
+type notifyFailedMsg struct{ err error }
 
-		return statusUpdateMsg{statuses}
+// osc wraps an operating system command sequence. Inside tmux, it is passed
+// through to the outer terminal, which needs "set -g allow-passthrough on".
+func osc(command string) string {
+	seq := "\x1b]" + command + "\x07"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
 	}
+	return seq
 }
 
-func fetchModuleHistory(name string) ([]CheckResult, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/status/%s", apiBaseURL, name))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// oscText makes text safe inside an OSC sequence, which control characters
+// would end, and in which ";" separates fields.
+func oscText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ';':
+			return ','
+		case r < 0x20 || r == 0x7f:
+			return ' '
+		}
+		return r
+	}, text)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// terminal is the output of the TUI. Bubble Tea writes each frame with a
+// single Write, and notifications are written through the same lock, so
+// they never land in the middle of a frame. It is still the *os.File that
+// Bubble Tea inspects for the terminal size.
+type terminal struct {
+	*os.File
+	mu sync.Mutex
+}
 
-	var history []CheckResult
-	if err := json.Unmarshal(body, &history); err != nil {
-		return nil, fmt.Errorf("bad json from api for %s: %w", name, err)
-	}
-	return history, nil
+func (t *terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.File.Write(p)
 }
 
-// Command to wait for the next tick.
-func (m model) waitForTick() tea.Cmd {
+// WriteString is used by Bubble Tea for control sequences; without it, the
+// one of the embedded file would bypass the lock.
+func (t *terminal) WriteString(s string) (int, error) {
+	return t.Write([]byte(s))
+}
+
+// screen is the terminal the program renders to.
+var screen = &terminal{File: os.Stdout}
+
+// writeTerminal writes seq to the terminal the TUI runs in, between frames.
+func writeTerminal(seq string) tea.Cmd {
 	return func() tea.Msg {
-		<-m.ticker.C
-		return fetchStatuses()()
+		if _, err := screen.WriteString(seq); err != nil {
+			return notifyFailedMsg{err}
+		}
+		return nil
 	}
 }
 
 // --- Bubble Tea Core ---
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(fetchStatuses(), m.waitForTick())
+	return tea.Batch(m.fetch(true), m.subscribe(), m.waitForTick())
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	  switch msg := msg.(type) {
 	  case tea.KeyMsg:
+			  if msg.String() == "ctrl+c" {
+					  m.quitting = true
+					  return m, tea.Quit
+			  }
+			  if m.detail != nil {
+					  return m.updateDetail(msg)
+			  }
+			  // While a filter is being typed, every key goes to the list.
+			  if m.list.SettingFilter() {
+					  break
+			  }
 			  switch msg.String() {
-			  case "ctrl+c", "q":
+			  case "q":
 					  m.quitting = true
-					  m.ticker.Stop()
 					  return m, tea.Quit
+			  case "enter":
+					  switch item := m.list.SelectedItem().(type) {
+					  case moduleItem:
+							  m.detail = &moduleKey{item.server, item.name}
+							  m.hover = -1
+							  m.viewport.SetContent(m.detailContent())
+							  m.viewport.GotoTop()
+					  case serverItem:
+							  item.srv.collapsed = !item.srv.collapsed
+							  return m, m.refreshItems()
+					  }
+					  return m, nil
+			  case "s":
+					  m.sortMode = (m.sortMode + 1) % sortMode(len(sortModeNames))
+					  return m, m.refreshItems()
+			  case "f":
+					  m.failingOnly = !m.failingOnly
+					  return m, m.refreshItems()
+			  case "w", "1", "2", "3", "4":
+					  return m, m.switchWindow(msg.String())
+			  case "?":
+					  m.showLegend = !m.showLegend
+					  m.resize()
+					  return m, nil
+			  case "n":
+					  m.showNotifications = !m.showNotifications
+					  m.resize()
+					  return m, nil
 			  case "r":
 					  m.refreshing = true
-					  return m, tea.Batch(fetchStatuses(), resetRefreshCmd())
+					  return m, tea.Batch(m.fetch(true), resetRefreshCmd())
 			  }
 	  case tea.WindowSizeMsg:
 			  m.width = msg.Width
+			  m.height = msg.Height
+			  m.resize()
+			  if m.detail != nil {
+					  m.viewport.SetContent(m.detailContent())
+			  }
 			  return m, nil
 	  case statusUpdateMsg:
-			  m.statuses = msg.statuses
-			  m.err = nil
-			  return m, m.waitForTick() // Wait for the next tick after a successful update.
-	  case errMsg:
-			  m.err = msg.err
-			  return m, m.waitForTick() // Still wait for the next tick even on error.
+			  var cmds []tea.Cmd
+			  for _, u := range msg.updates {
+					  // Drop history fetched before the window was switched.
+					  if u.window != m.window {
+							  continue
+					  }
+					  srv := m.servers[u.index]
+					  before := srv.moduleStates()
+					  srv.apply(u)
+					  cmds = append(cmds, m.record(srv, before))
+			  }
+			  return m, tea.Batch(append(cmds, m.updated())...)
+	  case tickMsg:
+			  // Poll the servers without a stream, and try to open theirs again.
+			  // Live servers may have gone quiet: refresh which modules are late.
+			  return m, tea.Batch(m.refreshItems(), m.fetch(false), m.subscribe(), m.waitForTick())
+	  case streamOpenedMsg:
+			  srv := m.servers[msg.server]
+			  srv.live, srv.subscribing = true, false
+			  // Results may have been missed since the last poll.
+			  return m, tea.Batch(fetchServers(m.servers, m.window, msg.server), nextEvent(msg.server, msg.events))
+	  case streamEventMsg:
+			  // A malformed event is skipped; the next poll or reconnection resyncs.
+			  srv := m.servers[msg.server]
+			  before := srv.moduleStates()
+			  srv.applyEvent(msg.event, m.window.span)
+			  return m, tea.Batch(m.record(srv, before), m.updated(), nextEvent(msg.server, msg.events))
+	  case streamClosedMsg:
+			  srv := m.servers[msg.server]
+			  srv.live, srv.subscribing = false, false
+			  return m, nil
 	  case refreshDoneMsg:
 			  m.refreshing = false
 			  return m, nil
+	  case notifyFailedMsg:
+			  m.notifyErr = msg.err
+			  return m, nil
 	  }
-	  return m, nil
+
+	  // Everything else (navigation, filtering, filter results) is the list's.
+	  var cmd tea.Cmd
+	  m.list, cmd = m.list.Update(msg)
+	  return m, cmd
+}
+
+// updated refreshes what shows the servers' data after it changed.
+func (m *model) updated() tea.Cmd {
+	m.resize()
+	if m.detail != nil {
+		m.viewport.SetContent(m.detailContent())
+	}
+	return m.refreshItems()
 }
 
 // Mensagem para resetar o estado de refresh
@@ -179,91 +1549,111 @@ func resetRefreshCmd() tea.Cmd {
 	  }
 }
 
-// MODIFIED: View now shows the specific error message for outages.
+// switchWindow shows the next history window for "w", or the nth one for a
+// digit, and fetches its history from every server.
+func (m *model) switchWindow(key string) tea.Cmd {
+	next := 0
+	if key == "w" {
+		for i, w := range historyWindows {
+			if w == m.window {
+				next = (i + 1) % len(historyWindows)
+			}
+		}
+	} else {
+		next = int(key[0] - '1')
+	}
+	if historyWindows[next] == m.window {
+		return nil
+	}
+	m.window = historyWindows[next]
+	m.refreshing = true
+	return tea.Batch(m.refreshItems(), m.fetch(true), resetRefreshCmd())
+}
+
+// updateDetail handles keys while a module's detail view is open. Keys not
+// handled here scroll the viewport (up/down, j/k, pgup/pgdn, space, u/d).
+func (m model) updateDetail(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "left", "h":
+		// Point at a cell of the chart, starting from the newest one.
+		if m.hover < 0 {
+			m.hover = m.chartWidth()
+		}
+		m.hover = max(m.hover-1, 0)
+		return m, nil
+	case "right", "l":
+		if m.hover >= 0 {
+			m.hover = min(m.hover+1, m.chartWidth()-1)
+		}
+		return m, nil
+	case "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "esc", "backspace":
+		m.detail = nil
+		return m, nil
+	case "r":
+		m.refreshing = true
+		return m, tea.Batch(m.fetch(true), resetRefreshCmd())
+	case "w", "1", "2", "3", "4":
+		m.hover = -1
+		return m, m.switchWindow(msg.String())
+	}
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
 func (m model) View() string {
 	   if m.quitting {
 			   return "Bye!\n"
 	   }
+	   if m.detail != nil {
+			   return m.detailView()
+	   }
 
-	   // Defina a largura mínima e máxima do historyBar
-	   // Reservar espaço para nome (20), uptime (17), status (12), margem (3)
-	   minBarWidth := 10
-	   reserved := 20 + 17 + 12 + 3
-	   barWidth := m.width - reserved
-	   if barWidth < minBarWidth {
-			   barWidth = minBarWidth
-	   } else if barWidth > 120 {
-			   barWidth = 120
+	   barWidth := barWidth(m.width)
+
+	   // With a single server, its status is the whole screen; with several,
+	   // each one gets a header in the list.
+	   single := m.servers[0]
+	   if len(m.servers) > 1 {
+			   single = nil
 	   }
 
 	   var b strings.Builder
-	   b.WriteString("Rsync Server Status (Last 24h)\n")
-	   b.WriteString(helpStyle.Render("Oldest →" + strings.Repeat("─", barWidth-4) + "→ Recent"))
-	   b.WriteString("\n\n")
+	   b.WriteString("Rsync Server Status (Last " + m.window.name + ")")
+	   if single == nil {
+			   b.WriteString(helpStyle.Render(fmt.Sprintf("  watching %d servers", len(m.servers))))
+	   } else if single.profile != "" {
+			   b.WriteString(helpStyle.Render("  " + single.profile + " · " + single.client.baseURL))
+	   }
+	   b.WriteString("\n")
+	   if single != nil && len(single.throughput) > 0 {
+			   b.WriteString(renderThroughput(single.throughput, barWidth))
+			   b.WriteString("\n")
+	   }
+	   // The axis sits right above the history bars.
+	   b.WriteString(strings.Repeat(" ", barColumn) + helpStyle.Render(renderAxis(newTimeline(time.Now(), m.window.span, barWidth))))
+	   // No blank line here: the list's first line holds the filter prompt.
+	   b.WriteString("\n")
 
-	   if len(m.statuses) == 0 {
-			   if m.err != nil {
-					   return fmt.Sprintf("Error fetching data: %v\n\n%s", m.err, helpStyle.Render("Press 'r' to retry, 'q' to quit."))
+	   if single != nil && len(single.statuses) == 0 {
+			   if single.err != nil {
+					   return fmt.Sprintf("Error fetching data: %v\n\n%s", single.err, helpStyle.Render("Press 'r' to retry, 'q' to quit."))
 			   }
 			   return "Fetching statuses...\n"
 	   }
 
-	   sortedNames := make([]string, 0, len(m.statuses))
-	   for name := range m.statuses {
-			   sortedNames = append(sortedNames, name)
+	   b.WriteString(m.list.View())
+	   b.WriteString("\n")
+	   if m.showNotifications {
+			   b.WriteString(m.notificationLog())
+			   b.WriteString("\n")
 	   }
-	   sort.Strings(sortedNames)
-
-	   for _, name := range sortedNames {
-			   history := m.statuses[name]
-			   bar := renderHistoryBar(history, barWidth)
-			   latestResult := CheckResult{IsUp: true, Message: "Operational"}
-			   if len(history) > 0 {
-					   latestResult = history[len(history)-1]
-			   }
-
-			   // Cálculo do uptime
-			   upCount := 0
-			   for _, check := range history {
-					   if check.IsUp {
-							   upCount++
-					   }
-			   }
-			   var uptimePercent float64
-			   if len(history) > 0 {
-					   uptimePercent = float64(upCount) / float64(len(history)) * 100.0
-			   }
-
-			   var statusText string
-			   var errorDetails string
-			   if !latestResult.IsUp {
-					   statusText = statusDownStyle.Render("Outage")
-					   // Simplifica: mostra só código rsync e primeira linha do erro
-					   var details string
-					   if latestResult.RsyncExitCode != 0 {
-							   details += fmt.Sprintf("Código rsync: %d. ", latestResult.RsyncExitCode)
-					   }
-					   var firstLine string
-					   if latestResult.RsyncOutput != "" {
-							   firstLine = strings.SplitN(latestResult.RsyncOutput, "\n", 2)[0]
-					   } else if latestResult.Message != "" {
-							   firstLine = strings.SplitN(latestResult.Message, "\n", 2)[0]
-					   }
-					   if details != "" || firstLine != "" {
-							   errorDetails = errorMsgStyle.Render(" Erro: " + details + firstLine)
-					   }
-			   } else if strings.Contains(bar, "196") {
-					   statusText = statusPartialStyle.Render("Partial Outage")
-					   errorDetails = errorMsgStyle.Render(" (Recent recovery)")
-			   } else {
-					   statusText = statusUpStyle.Render("Operational")
-			   }
-
-			   // Exibe o nome, uptime, barra, status e detalhes na mesma linha
-			   rawUptime := fmt.Sprintf("%.2f %%", uptimePercent)
-			   paddedUptime := fmt.Sprintf("%-10s uptime", rawUptime)
-			   uptimeStr := helpStyle.Render(paddedUptime)
-			   b.WriteString(fmt.Sprintf("%s %s %s %s%s\n", moduleNameStyle.Render(name), uptimeStr, bar, statusText, errorDetails))
+	   if m.showLegend {
+			   b.WriteString(m.legend())
+			   b.WriteString("\n")
 	   }
 
 	   // Estilo do botão de refresh
@@ -276,72 +1666,790 @@ func (m model) View() string {
 
 	   // Mostra erro ao lado do botão se existir
 	   var errorInline string
-	   if m.err != nil {
-			   errorInline = errorMsgStyle.Render(fmt.Sprintf("  Erro: %v", m.err))
+	   if single != nil && single.err != nil {
+			   errorInline = errorMsgStyle.Render(fmt.Sprintf("  Erro: %v", single.err))
 	   }
 
-	   b.WriteString(refreshBtn + "  " + helpStyle.Render("[q] quit") + errorInline)
+	   keys := "[j/k] select  [enter] details  [/] filter  [s] sort: " + sortModeNames[m.sortMode]
+	   if single == nil {
+			   keys = "[j/k] select  [enter] details/collapse  [/] filter  [s] sort: " + sortModeNames[m.sortMode]
+	   }
+	   keys = "[?] legend  [n] notifications  [w] window: " + m.window.name + "  " + keys
+	   if m.failingOnly {
+			   keys += "  [f] all modules"
+	   } else {
+			   keys += "  [f] failing only"
+	   }
+	   if m.list.IsFiltered() {
+			   keys += fmt.Sprintf("  [esc] clear filter %q", m.list.FilterValue())
+	   }
+	   b.WriteString(m.updateMode() + "  " + refreshBtn + "  " + helpStyle.Render(keys+"  [q] quit") + errorInline)
 	   return b.String()
 }
 
-func renderHistoryBar(history []CheckResult, width int) string {
-	if len(history) == 0 {
-		return strings.Repeat(" ", width)
+// notificationLines is how many lines the notification log toggled with
+// "n" takes: a title and the most recent transitions.
+const notificationLines = 6
+
+// notificationLog lists the most recent transitions, newest first.
+func (m model) notificationLog() string {
+	title := sectionStyle.Render("Notifications")
+	if m.notifyErr != nil {
+		title += errorMsgStyle.Render("  could not notify: " + m.notifyErr.Error())
+	}
+	lines := []string{ansi.Truncate(title, m.width, "…")}
+	for i := len(m.notifications) - 1; i >= 0 && len(lines) < notificationLines; i-- {
+		t := m.notifications[i]
+		where := t.module
+		if len(m.servers) > 1 {
+			where += " @ " + t.server
+		}
+		line := "  " + helpStyle.Render(t.at.Local().Format("Jan 2 15:04:05")) + "  "
+		if t.up {
+			line += statusUpStyle.Render("▲ "+where) + helpStyle.Render(" back up")
+		} else {
+			line += statusDownStyle.Render("▼ "+where) + helpStyle.Render(" down") + errorMsgStyle.Render(" "+t.err)
+		}
+		lines = append(lines, ansi.Truncate(line, m.width, "…"))
+	}
+	if len(m.notifications) == 0 {
+		lines = append(lines, helpStyle.Render("  No module has gone down or come back up since the TUI started."))
 	}
+	// Keep the pane's height, so the list above it does not jump.
+	for len(lines) < notificationLines {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
 
-	var b strings.Builder
-	totalChecks := len(history)
-	if totalChecks == 0 {
-		return strings.Repeat(" ", width)
+// legendLines is how many lines the legend toggled with "?" takes.
+const legendLines = 2
+
+// legend explains the colours of the history bar and latency sparkline and
+// the module states.
+func (m model) legend() string {
+	slow := "its server's -slow threshold"
+	if len(m.servers) == 1 {
+		slow = m.servers[0].slow.String()
 	}
+	bar := statusUpStyle.Render("█") + helpStyle.Render(" up  ") +
+		statusDegradedStyle.Render("█") + helpStyle.Render(" slow  ") +
+		statusDownStyle.Render("█") + helpStyle.Render(" down  ") +
+		noDataStyle.Render("░") + helpStyle.Render(" no checks  ") +
+		helpStyle.Render("▂▄▆█ check duration, ") + statusDegradedStyle.Render("yellow") + helpStyle.Render(" above "+slow) +
+		helpStyle.Render(", ") + statusDownStyle.Render("·") + helpStyle.Render(" failed")
+	states := statusDegradedStyle.Render("Degraded") + helpStyle.Render(": last check slow, or none for 2 polling intervals  ") +
+		statusPartialStyle.Render("Partial Outage") + helpStyle.Render(": failed recently  ") +
+		staleStyle.Render("Unknown") + helpStyle.Render(": monitor unreachable")
+	return ansi.Truncate(bar, m.width, "…") + "\n" + ansi.Truncate(states, m.width, "…")
+}
 
-	// If history is shorter than the bar width, display it directly.
-	if totalChecks <= width {
-		for _, check := range history {
-			if check.IsUp {
-				b.WriteString(statusUpStyle.Render("█"))
-			} else {
-				b.WriteString(statusDownStyle.Render("█"))
-			}
+// updateMode tells whether results are pushed live by the servers or polled
+// every refresh interval, which is the fallback when /events is unavailable.
+func (m model) updateMode() string {
+	live := 0
+	for _, srv := range m.servers {
+		if srv.live {
+			live++
+		}
+	}
+	switch live {
+	case len(m.servers):
+		return statusUpStyle.Render("● live")
+	case 0:
+		return helpStyle.Render("○ polling " + m.refresh.String())
+	default:
+		return statusPartialStyle.Render(fmt.Sprintf("◐ live %d/%d", live, len(m.servers)))
+	}
+}
+
+// detailChromeLines is how many lines of the detail view do not scroll: the
+// title, the summary, the chart with its latency line and axis, a blank line
+// and the key help.
+const detailChromeLines = 9
+
+// detailFailureOutputs is how many recent failures have their full rsync
+// output shown in the detail view.
+const detailFailureOutputs = 5
+
+// incident is a run of consecutive failed checks of a module.
+type incident struct {
+	start  time.Time
+	end    time.Time // first successful check after it; zero while ongoing
+	checks int
+	err    string // error of the first failed check
+}
+
+// findIncidents groups the failed checks of history into incidents, oldest
+// first. A summarized result that ends up but had failures closes an
+// incident, or is one on its own.
+func findIncidents(history []CheckResult) []incident {
+	var incidents []incident
+	ongoing := false
+	for _, check := range history {
+		_, failures := check.counts()
+		if failures > 0 && !ongoing {
+			incidents = append(incidents, incident{start: check.Timestamp, err: checkError(check)})
+			ongoing = true
+		}
+		if ongoing {
+			incidents[len(incidents)-1].checks += failures
+		}
+		if check.IsUp && ongoing {
+			incidents[len(incidents)-1].end = check.Timestamp
+			ongoing = false
+		}
+	}
+	return incidents
+}
+
+// checkError returns the first line describing why a check failed.
+func checkError(check CheckResult) string {
+	for _, text := range []string{check.Error, check.LastError, check.RsyncOutput, check.Message} {
+		if text != "" {
+			return strings.SplitN(text, "\n", 2)[0]
+		}
+	}
+	return ""
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string(r[:max(n, 0)])
+	}
+	return string(r[:n-1]) + "…"
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// detailLines renders the scrollable part of a module's detail view: its
+// incidents, every check newest first and the full output of recent failures.
+func (m model) detailLines() []string {
+	srv := m.servers[m.detail.server]
+	history := srv.statuses[m.detail.name]
+	width := max(m.width, 40)
+	var lines []string
+
+	incidents := findIncidents(history)
+	lines = append(lines, sectionStyle.Render(fmt.Sprintf("Incidents (%d)", len(incidents))))
+	if len(incidents) == 0 {
+		lines = append(lines, helpStyle.Render("  No failures in this period."))
+	}
+	for i := len(incidents) - 1; i >= 0; i-- {
+		inc := incidents[i]
+		end, duration := "ongoing", time.Since(inc.start)
+		if !inc.end.IsZero() {
+			end, duration = inc.end.Local().Format("15:04:05"), inc.end.Sub(inc.start)
 		}
-		b.WriteString(strings.Repeat(" ", width-totalChecks)) // Pad with space
-		return b.String()
+		head := fmt.Sprintf("  %s → %-8s  %-9s %3d checks  ", inc.start.Local().Format(timeLayout), end, duration.Round(time.Second), inc.checks)
+		lines = append(lines, statusDownStyle.Render(head)+errorMsgStyle.Render(truncate(inc.err, width-len([]rune(head)))))
 	}
 
-	// Otherwise, compress the history into buckets.
-	bucketSize := float64(totalChecks) / float64(width)
-	for i := 0; i < width; i++ {
-		start := int(float64(i) * bucketSize)
-		end := int(float64(i+1) * bucketSize)
-		if end > totalChecks {
-			end = totalChecks
+	title := fmt.Sprintf("Checks (%d, newest first)", len(history))
+	if _, total := uptime(history); total > len(history) {
+		title = fmt.Sprintf("Checks (%d, summarized per %s, newest first)", total, m.window.resolution())
+	}
+	lines = append(lines, "", sectionStyle.Render(title))
+	for i := len(history) - 1; i >= 0; i-- {
+		check := history[i]
+		// A summarized result tells how many checks it stands for.
+		summary := ""
+		if check.Failures > 0 {
+			summary = fmt.Sprintf("%d checks, %d failed ", check.Checks, check.Failures)
+		} else if check.Checks > 1 {
+			summary = fmt.Sprintf("%d checks", check.Checks)
 		}
-		if start >= end { // Ensure bucket is not empty
-			if start > 0 {
-				start--
+		if check.IsUp {
+			took := ""
+			if check.DurationMs > 0 {
+				took = "  " + formatLatency(check.DurationMs)
+			}
+			line := fmt.Sprintf("  %s  ", check.Timestamp.Local().Format(timeLayout))
+			if isSlow(check, srv.slow) {
+				line += statusDegradedStyle.Render("✔ up" + took + " (slow)")
 			} else {
-				continue // Should not happen with correct logic
+				line += statusUpStyle.Render("✔ up") + helpStyle.Render(took)
+			}
+			if check.Failures > 0 {
+				line += "  " + statusDownStyle.Render(summary) + errorMsgStyle.Render(truncate(check.LastError, width-ansi.StringWidth(line)-len(summary)-2))
+			} else if summary != "" {
+				line += helpStyle.Render("  " + summary)
 			}
+			lines = append(lines, line)
+			continue
 		}
+		head := fmt.Sprintf("  %s  ✘ exit %-3d %-3d %s", check.Timestamp.Local().Format(timeLayout), check.RsyncExitCode, check.HTTPStatus, summary)
+		detail := checkError(check)
+		if check.Category != "" {
+			detail = "[" + check.Category + "] " + detail
+		}
+		lines = append(lines, statusDownStyle.Render(head)+errorMsgStyle.Render(truncate(detail, width-len([]rune(head)))))
+	}
 
-		isUp := true
-		for _, check := range history[start:end] {
-			if !check.IsUp {
-				isUp = false
-				break
+	lines = append(lines, "", sectionStyle.Render("Recent failure output"))
+	shown := 0
+	for i := len(history) - 1; i >= 0 && shown < detailFailureOutputs; i-- {
+		check := history[i]
+		if check.IsUp {
+			continue
+		}
+		shown++
+		lines = append(lines, helpStyle.Render(fmt.Sprintf("  ── %s (exit %d)", check.Timestamp.Local().Format(timeLayout), check.RsyncExitCode)))
+		output := check.RsyncOutput
+		if output == "" {
+			output = checkError(check)
+		}
+		for _, line := range strings.Split(output, "\n") {
+			lines = append(lines, "  "+truncate(strings.TrimRight(line, "\r"), width-2))
+		}
+	}
+	if shown == 0 {
+		lines = append(lines, helpStyle.Render("  No failures in this period."))
+	}
+	return lines
+}
+
+// detailContent is what the detail viewport scrolls through.
+func (m model) detailContent() string {
+	return strings.Join(m.detailLines(), "\n")
+}
+
+// chartWidth is the width of the history chart of the detail view.
+func (m model) chartWidth() int {
+	return max(m.width-1, 20)
+}
+
+// renderHover replaces the axis under the detail chart while a cell is
+// pointed at: it marks the cell and tells its time span and what the
+// checks in it found.
+func renderHover(history []CheckResult, tl timeline, col int) string {
+	from, to := tl.slotStart(col), tl.slotStart(col+1)
+	checks, failed := 0, 0
+	for _, check := range history {
+		if check.Timestamp.Before(from) || !check.Timestamp.Before(to) {
+			continue
+		}
+		n, failures := check.counts()
+		checks += n
+		failed += failures
+	}
+	label := fmt.Sprintf("%s – %s  ", from.Local().Format("Jan 2 15:04"), to.Local().Format("15:04"))
+	switch {
+	case checks == 0:
+		label += "no checks"
+	case failed == 0:
+		label += fmt.Sprintf("%d checks, all up", checks)
+	default:
+		label += fmt.Sprintf("%d checks, %d failed", checks, failed)
+	}
+
+	// The label goes on the side of the marker with more room.
+	if col+2+len([]rune(label)) <= tl.width || col < tl.width/2 {
+		return strings.Repeat(" ", col) + selectedStyle.Render("▲") + " " + helpStyle.Render(label)
+	}
+	pad := max(col-1-len([]rune(label)), 0)
+	return strings.Repeat(" ", pad) + helpStyle.Render(label) + " " + selectedStyle.Render("▲")
+}
+
+// detailView shows one module: a larger history chart above the scrollable
+// incidents, checks and failure output.
+func (m model) detailView() string {
+	srv, name := m.servers[m.detail.server], m.detail.name
+	history := srv.statuses[name]
+	width := m.chartWidth()
+
+	var b strings.Builder
+	b.WriteString(lipgloss.NewStyle().Bold(true).Render(name))
+	if len(m.servers) > 1 {
+		b.WriteString(helpStyle.Render(" @ " + srv.name))
+	}
+	if desc := srv.descriptions[name]; desc != "" {
+		b.WriteString(errorMsgStyle.Render(" — " + desc))
+	}
+	b.WriteString("\n")
+
+	latest := CheckResult{}
+	if len(history) > 0 {
+		latest = history[len(history)-1]
+	}
+	switch {
+	case len(history) == 0:
+		b.WriteString(helpStyle.Render("No checks yet."))
+	case !latest.IsUp:
+		b.WriteString(statusDownStyle.Render("Outage"))
+	case isLate(history, srv.checkEvery, time.Now()):
+		b.WriteString(statusDegradedStyle.Render(fmt.Sprintf("Degraded: no check for %s", time.Since(latest.Timestamp).Round(time.Minute))))
+	case isSlow(latest, srv.slow):
+		b.WriteString(statusDegradedStyle.Render(fmt.Sprintf("Degraded: last check took %s (slow above %s)", formatLatency(latest.DurationMs), srv.slow)))
+	default:
+		b.WriteString(statusUpStyle.Render("Operational"))
+	}
+	if up, total := uptime(history); total > 0 {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("  %.2f %% uptime over %d checks in the last %s", float64(up)/float64(total)*100, total, m.window.name)))
+	}
+	b.WriteString("\n")
+
+	tl := newTimeline(time.Now(), m.window.span, width)
+	bar := renderHistoryBar(history, tl, srv.slow, srv.checkEvery)
+	for i := 0; i < 3; i++ {
+		b.WriteString(bar + "\n")
+	}
+	if m.hover >= 0 && m.hover < tl.width {
+		b.WriteString(renderHover(history, tl, m.hover) + "\n")
+	} else {
+		b.WriteString(helpStyle.Render(renderAxis(tl)) + "\n")
+	}
+	// The label and the latest duration take 14 columns.
+	b.WriteString(helpStyle.Render("Latency ") + renderLatency(history, max(width-14, 1), srv.slow))
+	b.WriteString("\n\n")
+
+	b.WriteString(m.viewport.View())
+
+	position := ""
+	if m.viewport.TotalLineCount() > m.viewport.VisibleLineCount() {
+		position = fmt.Sprintf("  %3.0f%%", m.viewport.ScrollPercent()*100)
+	}
+	b.WriteString("\n" + m.updateMode() + "  " + helpStyle.Render("[j/k pgup/pgdn] scroll  [h/l] point at time  [w] window: "+m.window.name+"  [esc] back  [r] refresh now  [q] quit"+position))
+	return b.String()
+}
+
+// sparkBlocks are the block characters used for sparklines, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// renderThroughput draws the benchmark history as a sparkline followed by the
+// most recent measurement. Failed probes are drawn as red dots.
+func renderThroughput(results []ThroughputResult, width int) string {
+	if len(results) > width {
+		results = results[len(results)-width:]
+	}
+	var peak float64
+	for _, r := range results {
+		if r.Error == "" && r.BytesPerSec > peak {
+			peak = r.BytesPerSec
+		}
+	}
+
+	var spark strings.Builder
+	for _, r := range results {
+		if r.Error != "" {
+			spark.WriteString(statusDownStyle.Render("·"))
+			continue
+		}
+		idx := 0
+		if peak > 0 {
+			idx = int(r.BytesPerSec / peak * float64(len(sparkBlocks)-1))
+		}
+		spark.WriteRune(sparkBlocks[idx])
+	}
+
+	latest := results[len(results)-1]
+	latestStr := "probe failed"
+	if latest.Error == "" {
+		latestStr = formatRate(latest.BytesPerSec)
+	}
+	return helpStyle.Render("Throughput ") + statusUpStyle.Render(spark.String()) + helpStyle.Render(" "+latestStr)
+}
+
+// formatRate formats a transfer rate in bytes per second using binary units.
+func formatRate(bps float64) string {
+	units := []string{"B/s", "KiB/s", "MiB/s", "GiB/s"}
+	i := 0
+	for bps >= 1024 && i < len(units)-1 {
+		bps /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", bps, units[i])
+}
+
+// timeline maps wall-clock time onto the cells of a history bar: width
+// slots of equal length, the last one holding the current time. Slots are
+// aligned to multiples of their length, so the bars of every module line up.
+type timeline struct {
+	start time.Time
+	slot  time.Duration
+	width int
+}
+
+func newTimeline(now time.Time, window time.Duration, width int) timeline {
+	width = max(width, 1)
+	slot := max(window/time.Duration(width), time.Second)
+	end := now.Truncate(slot).Add(slot)
+	return timeline{start: end.Add(-slot * time.Duration(width)), slot: slot, width: width}
+}
+
+// slotStart is the time at which the i-th slot begins.
+func (t timeline) slotStart(i int) time.Time {
+	return t.start.Add(t.slot * time.Duration(i))
+}
+
+// renderHistoryBar draws one cell per time slot: red if a check in it failed,
+// yellow if one was slower than slow, green otherwise. A slot without checks
+// takes the state of the previous check while it is less than two polling
+// intervals old, and is grey otherwise: a gap in the checks, such as a server
+// restart or stuck checks, or a time before the module was first checked.
+func renderHistoryBar(history []CheckResult, tl timeline, slow, checkEvery time.Duration) string {
+	// Without the polling interval, a check only covers its own slot.
+	carry := 2 * checkEvery
+	if checkEvery <= 0 {
+		carry = tl.slot
+	}
+
+	var b strings.Builder
+	next := 0 // first check not yet drawn
+	var last *CheckResult
+	for i := 0; i < tl.width; i++ {
+		slotStart, slotEnd := tl.slotStart(i), tl.slotStart(i+1)
+		first := next
+		for next < len(history) && history[next].Timestamp.Before(slotEnd) {
+			next++
+		}
+		var inSlot []CheckResult
+		for _, check := range history[first:next] {
+			if !check.Timestamp.Before(slotStart) {
+				inSlot = append(inSlot, check)
 			}
 		}
+		switch {
+		case len(inSlot) > 0:
+			b.WriteString(bucketCell(inSlot, slow))
+		case last != nil && slotStart.Sub(last.Timestamp) < carry:
+			// Only the state the module was left in carries over, not the
+			// failures summarized with it.
+			state := *last
+			state.Checks, state.Failures = 0, 0
+			b.WriteString(bucketCell([]CheckResult{state}, slow))
+		default:
+			b.WriteString(noDataStyle.Render("░"))
+		}
+		if next > 0 {
+			last = &history[next-1]
+		}
+	}
+	return b.String()
+}
+
+// renderAxis labels the slots of a timeline with evenly spaced times, e.g.
+// "06:00", and its end with "now".
+func renderAxis(tl timeline) string {
+	axis := []rune(strings.Repeat("─", tl.width))
+	const now = "now"
+	if tl.width < len(now) {
+		return string(axis)
+	}
+	copy(axis[tl.width-len(now):], []rune(now))
+
+	// The shortest step that leaves room for a label and a gap.
+	steps := []time.Duration{time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+		time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 48 * time.Hour, 7 * 24 * time.Hour}
+	step := steps[len(steps)-1]
+	for _, s := range steps {
+		if s >= tl.slot*9 {
+			step = s
+			break
+		}
+	}
+	layout := "15:04"
+	if step >= 24*time.Hour {
+		layout = "Jan 2"
+	}
+
+	// Ticks fall on round local times, e.g. midnight rather than 21:00 UTC.
+	_, offset := tl.start.Local().Zone()
+	shift := time.Duration(offset) * time.Second
+	free := 0 // first column a label may use
+	for tick := tl.start.Add(shift).Truncate(step).Add(step).Add(-shift); ; tick = tick.Add(step) {
+		col := int(tick.Sub(tl.start) / tl.slot)
+		label := []rune(tick.Local().Format(layout))
+		// The tick, the label and a gap must fit before "now".
+		if col+len(label)+2 > tl.width-len(now) {
+			break
+		}
+		if col >= free {
+			axis[col] = '┴'
+			copy(axis[col+1:], label)
+			free = col + len(label) + 2
+		}
+	}
+	return string(axis)
+}
+
+// bucketCell is the history bar cell of a bucket of checks.
+func bucketCell(checks []CheckResult, slow time.Duration) string {
+	style := statusUpStyle
+	for _, check := range checks {
+		if _, failures := check.counts(); failures > 0 {
+			return statusDownStyle.Render("█")
+		}
+		if isSlow(check, slow) {
+			style = statusDegradedStyle
+		}
+	}
+	return style.Render("█")
+}
+
+// latencyWidth is how many recent checks the latency sparkline shows, and
+// latencyColumns the width of the sparkline with the latest duration.
+const (
+	latencyWidth   = 8
+	latencyColumns = latencyWidth + 7
+)
+
+// renderLatency draws how long the last width checks took, followed by the
+// latest duration. The scale tops at the slowest check or at slow, so that
+// fast, steady checks stay low; slow checks are yellow, failed ones red dots.
+func renderLatency(history []CheckResult, width int, slow time.Duration) string {
+	if len(history) > width {
+		history = history[len(history)-width:]
+	}
+	peak := slow
+	timed := false
+	for _, check := range history {
+		d := time.Duration(check.DurationMs) * time.Millisecond
+		if check.IsUp && d > peak {
+			peak = d
+		}
+		timed = timed || check.DurationMs > 0
+	}
+	if !timed {
+		// The server does not time its checks.
+		return strings.Repeat(" ", width) + helpStyle.Render(fmt.Sprintf(" %5s", "–"))
+	}
 
-		if isUp {
-			b.WriteString(statusUpStyle.Render("█"))
+	var spark strings.Builder
+	spark.WriteString(strings.Repeat(" ", width-len(history)))
+	for _, check := range history {
+		if !check.IsUp {
+			spark.WriteString(statusDownStyle.Render("·"))
+			continue
+		}
+		d := time.Duration(check.DurationMs) * time.Millisecond
+		block := string(sparkBlocks[int(float64(d)/float64(peak)*float64(len(sparkBlocks)-1))])
+		if isSlow(check, slow) {
+			spark.WriteString(statusDegradedStyle.Render(block))
 		} else {
-			b.WriteString(statusDownStyle.Render("█"))
+			spark.WriteString(helpStyle.Render(block))
 		}
 	}
-	return b.String()
+	latest := history[len(history)-1]
+	latestStr := "–"
+	if latest.IsUp {
+		latestStr = formatLatency(latest.DurationMs)
+	}
+	return spark.String() + helpStyle.Render(fmt.Sprintf(" %5s", latestStr))
+}
+
+// formatLatency formats a check duration compactly, e.g. "850ms" or "12s".
+func formatLatency(ms int64) string {
+	switch {
+	case ms < 1000:
+		return fmt.Sprintf("%dms", ms)
+	case ms < 10000:
+		return fmt.Sprintf("%.1fs", float64(ms)/1000)
+	}
+	return fmt.Sprintf("%ds", ms/1000)
+}
+
+// --- One-shot mode ---
+
+// Exit codes of -once, those of Nagios plugins.
+const (
+	exitOK       = 0
+	exitWarning  = 1 // some module is degraded
+	exitCritical = 2 // some module is down
+	exitUnknown  = 3 // a server could not be reached, or the config is wrong
+)
+
+// moduleSummary is one module in the output of -once.
+type moduleSummary struct {
+	Server    string    `json:"server"`
+	Module    string    `json:"module"`
+	State     string    `json:"state"` // up, degraded, down or unknown
+	Uptime    float64   `json:"uptime"`
+	Checks    int       `json:"checks"`
+	LastCheck time.Time `json:"last_check,omitzero"`
+	LastError string    `json:"last_error,omitempty"` // of the most recent failure, even if up since
+	ErrorAt   time.Time `json:"last_error_at,omitzero"`
+}
+
+// serverSummary is one server in the output of -once.
+type serverSummary struct {
+	Server string `json:"server"`
+	URL    string `json:"url"`
+	Error  string `json:"error,omitempty"`
+}
+
+// state names the state of the module in the output of -once.
+func (i moduleItem) state() string {
+	switch {
+	case i.stale || len(i.history) == 0 || i.history[len(i.history)-1].Timestamp.IsZero():
+		// Not checked yet, or its history could not be fetched.
+		return "unknown"
+	case i.failing():
+		return "down"
+	case i.degraded():
+		return "degraded"
+	}
+	return "up"
+}
+
+// summarize describes a module for -once.
+func (i moduleItem) summarize(server string) moduleSummary {
+	_, checks := uptime(i.history)
+	s := moduleSummary{Server: server, Module: i.name, State: i.state(), Uptime: i.uptime(), Checks: checks}
+	if len(i.history) > 0 {
+		s.LastCheck = i.history[len(i.history)-1].Timestamp
+	}
+	for j := len(i.history) - 1; j >= 0; j-- {
+		if _, failures := i.history[j].counts(); failures > 0 {
+			s.LastError, s.ErrorAt = checkError(i.history[j]), i.history[j].Timestamp
+			break
+		}
+	}
+	return s
+}
+
+// runOnce fetches every server once, prints the state of each module as a
+// text table or JSON to w, and returns the exit code of a Nagios check.
+func runOnce(w io.Writer, servers []*server, window historyWindow, output string) int {
+	indices := make([]int, len(servers))
+	for i := range servers {
+		indices[i] = i
+	}
+	for _, u := range fetchServers(servers, window, indices...)().(statusUpdateMsg).updates {
+		servers[u.index].apply(u)
+	}
+
+	var modules []moduleSummary
+	var reached []serverSummary
+	var unreachable []string
+	for i, srv := range servers {
+		sum := serverSummary{Server: srv.name, URL: srv.client.baseURL}
+		if srv.err != nil {
+			sum.Error = srv.err.Error()
+			unreachable = append(unreachable, srv.name)
+		}
+		reached = append(reached, sum)
+		var items []moduleItem
+		for name := range srv.statuses {
+			items = append(items, srv.item(i, name, window.span))
+		}
+		sortItems(items, sortByName)
+		for _, item := range items {
+			modules = append(modules, item.summarize(srv.name))
+		}
+	}
+
+	byState := make(map[string][]string)
+	for _, m := range modules {
+		name := m.Module
+		if len(servers) > 1 {
+			name += "@" + m.Server
+		}
+		byState[m.State] = append(byState[m.State], name)
+	}
+	code, status, summary := exitOK, "OK", fmt.Sprintf("%d modules up", len(modules))
+	switch {
+	case len(byState["down"]) > 0:
+		code, status = exitCritical, "CRITICAL"
+		summary = fmt.Sprintf("%d of %d modules down: %s", len(byState["down"]), len(modules), strings.Join(byState["down"], ", "))
+	case len(unreachable) > 0:
+		code, status = exitUnknown, "UNKNOWN"
+		summary = "could not reach " + strings.Join(unreachable, ", ")
+	case len(byState["unknown"]) > 0:
+		code, status = exitUnknown, "UNKNOWN"
+		summary = fmt.Sprintf("%d of %d modules not checked: %s", len(byState["unknown"]), len(modules), strings.Join(byState["unknown"], ", "))
+	case len(byState["degraded"]) > 0:
+		code, status = exitWarning, "WARNING"
+		summary = fmt.Sprintf("%d of %d modules degraded: %s", len(byState["degraded"]), len(modules), strings.Join(byState["degraded"], ", "))
+	case len(modules) == 0:
+		code, status, summary = exitUnknown, "UNKNOWN", "no modules monitored"
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Status  string          `json:"status"`
+			Summary string          `json:"summary"`
+			Window  string          `json:"window"`
+			Servers []serverSummary `json:"servers"`
+			Modules []moduleSummary `json:"modules"`
+		}{strings.ToLower(status), summary, window.name, reached, modules})
+		return code
+	}
+
+	// The first line is the status line of a Nagios plugin.
+	fmt.Fprintf(w, "RSYNCUPTIME %s - %s\n", status, summary)
+	for _, srv := range reached {
+		if srv.Error != "" {
+			fmt.Fprintf(w, "%s (%s): %s\n", srv.Server, srv.URL, srv.Error)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "MODULE\tSTATE\tUPTIME (" + window.name + ")\tLAST ERROR"
+	if len(servers) > 1 {
+		header = "SERVER\t" + header
+	}
+	fmt.Fprintln(tw, header)
+	for _, m := range modules {
+		lastError := ""
+		if m.LastError != "" {
+			lastError = m.ErrorAt.Local().Format(timeLayout) + "  " + m.LastError
+		}
+		row := fmt.Sprintf("%s\t%s\t%.2f %%\t%s", m.Module, m.State, m.Uptime, lastError)
+		if len(servers) > 1 {
+			row = m.Server + "\t" + row
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+	return code
 }
 
 func main() {
+	once := flag.Bool("once", false, "fetch once, print the state of every module and exit: 0 if all are up, 1 if some is degraded, 2 if some is down, 3 if unknown")
+	output := flag.String("output", "text", "format of -once: text or json")
+	windowName := flag.String("window", historyWindows[1].name, "history shown at start, and summarized by -once: 1h, 24h, 7d or 30d")
+	configs, err := loadConfig()
+	// To a Nagios check, a broken setup is unknown rather than critical.
+	badSetup := 2
+	if *once {
+		badSetup = exitUnknown
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(badSetup)
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown output format '%s' (use text or json)\n", *output)
+		os.Exit(badSetup)
+	}
+	window, ok := findWindow(*windowName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown window '%s' (use 1h, 24h, 7d or 30d)\n", *windowName)
+		os.Exit(badSetup)
+	}
+	// Every server is polled on each tick, at the shortest of their intervals.
+	var servers []*server
+	refresh := configs[0].Refresh
+	for _, cfg := range configs {
+		client, err := newAPIClient(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", cfg.name(), err)
+			os.Exit(badSetup)
+		}
+		servers = append(servers, newServer(cfg, client))
+		refresh = min(refresh, cfg.Refresh)
+	}
+	if *once {
+		os.Exit(runOnce(os.Stdout, servers, window, *output))
+	}
+
 	if _, ok := os.LookupEnv("DEBUG"); ok {
 		f, err := tea.LogToFile("tui-debug.log", "debug")
 		if err != nil {
@@ -351,7 +2459,9 @@ func main() {
 		defer f.Close()
 	}
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	m := initialModel(servers, refresh)
+	m.window = window
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithOutput(screen))
 	if _, err := p.Run(); err != nil {
 		log.Fatalf("Error running program: %v", err)
 	}
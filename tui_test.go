@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// --- One-shot mode tests ---
+
+// fakeAPI serves the endpoints the TUI fetches, with history for each module,
+// and records the window asked for.
+type fakeAPI struct {
+	mu      sync.Mutex
+	history map[string][]CheckResult
+	windows []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/":
+		modules := make(map[string]map[string]string)
+		for name := range f.history {
+			modules[name] = map[string]string{"endpoint": "/status/" + name, "description": name + " mirror"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"monitored_modules": modules, "polling_interval_s": 300})
+	case strings.HasPrefix(r.URL.Path, "/status/"):
+		f.mu.Lock()
+		f.windows = append(f.windows, r.URL.Query().Get("window"))
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(f.history[strings.TrimPrefix(r.URL.Path, "/status/")])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// testServer returns a server watching the API at apiURL.
+func testServer(t *testing.T, apiURL string) *server {
+	t.Helper()
+	cfg := clientConfig{APIURL: apiURL, Refresh: time.Minute, Slow: defaultSlowThreshold}
+	client, err := newAPIClient(cfg)
+	if err != nil {
+		t.Fatalf("newAPIClient: %v", err)
+	}
+	return newServer(cfg, client)
+}
+
+func TestRunOnceExitCodes(t *testing.T) {
+	now := time.Now()
+	up := CheckResult{IsUp: true, Timestamp: now, DurationMs: 200}
+	slow := CheckResult{IsUp: true, Timestamp: now, DurationMs: 9000}
+	down := CheckResult{IsUp: false, Error: "@ERROR: max connections (10) reached -- try again later", Timestamp: now}
+
+	tests := []struct {
+		name    string
+		history map[string][]CheckResult
+		code    int
+		status  string
+	}{
+		{"all up", map[string][]CheckResult{"debian": {up}, "ubuntu": {up}}, exitOK, "RSYNCUPTIME OK - 2 modules up"},
+		{"slow check", map[string][]CheckResult{"debian": {up}, "ubuntu": {up, slow}}, exitWarning, "RSYNCUPTIME WARNING - 1 of 2 modules degraded: ubuntu"},
+		{"module down", map[string][]CheckResult{"debian": {up, down}, "ubuntu": {slow}}, exitCritical, "RSYNCUPTIME CRITICAL - 1 of 2 modules down: debian"},
+		{"not checked yet", map[string][]CheckResult{"debian": {up}, "ubuntu": {}}, exitUnknown, "RSYNCUPTIME UNKNOWN - 1 of 2 modules not checked: ubuntu"},
+		{"no modules", map[string][]CheckResult{}, exitUnknown, "RSYNCUPTIME UNKNOWN - no modules monitored"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(&fakeAPI{history: tt.history})
+			defer api.Close()
+
+			var out bytes.Buffer
+			code := runOnce(&out, []*server{testServer(t, api.URL)}, historyWindows[1], "text")
+			if code != tt.code {
+				t.Errorf("Expected exit code %d, got %d", tt.code, code)
+			}
+			if first, _, _ := strings.Cut(out.String(), "\n"); first != tt.status {
+				t.Errorf("Expected status line %q, got %q", tt.status, first)
+			}
+		})
+	}
+}
+
+func TestRunOnceUnreachableServer(t *testing.T) {
+	api := httptest.NewServer(&fakeAPI{history: map[string][]CheckResult{}})
+	url := api.URL
+	api.Close()
+
+	var out bytes.Buffer
+	srv := testServer(t, url)
+	if code := runOnce(&out, []*server{srv}, historyWindows[1], "text"); code != exitUnknown {
+		t.Errorf("Expected exit code %d for an unreachable server, got %d", exitUnknown, code)
+	}
+	if !strings.HasPrefix(out.String(), "RSYNCUPTIME UNKNOWN - could not reach "+srv.name+"\n") {
+		t.Errorf("Expected the unreachable server in the status line, got %q", out.String())
+	}
+}
+
+func TestRunOnceJSON(t *testing.T) {
+	now := time.Now()
+	api := &fakeAPI{history: map[string][]CheckResult{
+		"debian": {{IsUp: true, Timestamp: now.Add(-time.Hour)}, {IsUp: false, Error: "@ERROR: Unknown module 'debian'", Timestamp: now}},
+	}}
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	window, _ := findWindow("7d")
+	if code := runOnce(&out, []*server{testServer(t, ts.URL)}, window, "json"); code != exitCritical {
+		t.Errorf("Expected exit code %d, got %d", exitCritical, code)
+	}
+	var body struct {
+		Status  string          `json:"status"`
+		Window  string          `json:"window"`
+		Modules []moduleSummary `json:"modules"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, out.String())
+	}
+	if body.Status != "critical" || body.Window != "7d" {
+		t.Errorf("Expected critical over 7d, got %q over %q", body.Status, body.Window)
+	}
+	if len(body.Modules) != 1 || body.Modules[0].State != "down" || body.Modules[0].Uptime != 50 ||
+		body.Modules[0].LastError != "@ERROR: Unknown module 'debian'" {
+		t.Errorf("Unexpected modules: %+v", body.Modules)
+	}
+	if strings.Join(api.windows, ",") != "7d" {
+		t.Errorf("Expected the history of the 7d window to be fetched, got %v", api.windows)
+	}
+}
+
+// --- Configuration tests ---
+
+// loadTestConfig runs loadConfig with args as the command line, in an
+// environment without any RSYNCUPTIME_ settings besides env.
+func loadTestConfig(t *testing.T, env map[string]string, args ...string) ([]clientConfig, error) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, name := range []string{"CONFIG", "PROFILE", "API_URL", "REFRESH_SECONDS", "SLOW_SECONDS", "TOKEN",
+		"CA_FILE", "CERT_FILE", "KEY_FILE", "INSECURE", "NOTIFY"} {
+		t.Setenv("RSYNCUPTIME_"+name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	savedArgs, savedFlags := os.Args, flag.CommandLine
+	defer func() { os.Args, flag.CommandLine = savedArgs, savedFlags }()
+	os.Args = append([]string{"tui"}, args...)
+	flag.CommandLine = flag.NewFlagSet("tui", flag.ContinueOnError)
+	return loadConfig()
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	configs, err := loadTestConfig(t, nil)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	want := clientConfig{APIURL: defaultAPIBaseURL, Refresh: defaultRefreshInterval, Slow: defaultSlowThreshold}
+	if len(configs) != 1 || configs[0] != want {
+		t.Errorf("Expected only the defaults %+v, got %+v", want, configs)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tui.json")
+	file := `{"default": "prod", "profiles": {"prod": {
+		"api_url": "https://uptime.example/", "refresh": "30s", "slow": "10s",
+		"token": "profile-token", "ca_file": "/etc/profile-ca.pem", "notify": "bell"}}}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := loadTestConfig(t, map[string]string{
+		"RSYNCUPTIME_CONFIG":          path,
+		"RSYNCUPTIME_REFRESH_SECONDS": "20",
+		"RSYNCUPTIME_TOKEN":           "env-token",
+		"RSYNCUPTIME_CA_FILE":         "/etc/env-ca.pem",
+	}, "-token", "flag-token")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	want := clientConfig{
+		Profile: "prod",
+		APIURL:  "https://uptime.example", // profile over default
+		Refresh: 20 * time.Second,         // env over profile
+		Slow:    10 * time.Second,         // profile over default
+		Token:   "flag-token",             // flag over env over profile
+		CAFile:  "/etc/env-ca.pem",        // env over profile
+		Notify:  "bell",                   // profile
+	}
+	if len(configs) != 1 || configs[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, configs)
+	}
+
+	// API URLs from flags replace the profile's endpoint, keeping its settings.
+	configs, err = loadTestConfig(t, map[string]string{"RSYNCUPTIME_CONFIG": path, "RSYNCUPTIME_API_URL": "http://env.example"},
+		"-api-url", "http://a.example,http://b.example", "-refresh", "5s")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(configs) != 2 || configs[0].APIURL != "http://a.example" || configs[1].APIURL != "http://b.example" {
+		t.Fatalf("Expected the endpoints of -api-url, got %+v", configs)
+	}
+	for _, cfg := range configs {
+		if cfg.Profile != "" || cfg.Refresh != 5*time.Second || cfg.Slow != 10*time.Second || cfg.Token != "profile-token" {
+			t.Errorf("Expected the profile's settings with the flag's refresh, got %+v", cfg)
+		}
+	}
+}
+
+func TestLoadConfigMissingProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tui.json")
+	if err := os.WriteFile(path, []byte(`{"profiles": {"prod": {"api_url": "https://uptime.example"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTestConfig(t, nil, "-config", path, "-profile", "staging"); err == nil {
+		t.Error("Expected an error for a profile missing from the config file")
+	}
+}
+
+// --- Rendering tests ---
+
+func TestTimelineEndsAtNow(t *testing.T) {
+	now := time.Date(2025, 7, 29, 12, 34, 56, 0, time.UTC)
+	tl := newTimeline(now, time.Hour, 60)
+	if tl.slot != time.Minute {
+		t.Errorf("Expected 1m slots for an hour over 60 cells, got %v", tl.slot)
+	}
+	last := tl.slotStart(tl.width - 1)
+	if now.Before(last) || !now.Before(last.Add(tl.slot)) {
+		t.Errorf("Expected now in the last slot [%v, %v), got %v", last, last.Add(tl.slot), now)
+	}
+
+	axis := []rune(renderAxis(tl))
+	if len(axis) != tl.width || !strings.HasSuffix(string(axis), "now") {
+		t.Errorf("Expected a %d cell axis ending in now, got %q", tl.width, string(axis))
+	}
+
+	// A window shorter than the width still uses whole seconds.
+	if tl := newTimeline(now, 10*time.Second, 60); tl.slot != time.Second {
+		t.Errorf("Expected 1s slots at least, got %v", tl.slot)
+	}
+}
+
+// --- Notification tests ---
+
+func TestTransitions(t *testing.T) {
+	now := time.Now()
+	srv := &server{name: "mirror", statuses: map[string][]CheckResult{
+		"debian": {{IsUp: true, Timestamp: now}, {IsUp: false, Error: "@ERROR: max connections (10) reached", Timestamp: now}},
+		"ubuntu": {{IsUp: false, Timestamp: now}, {IsUp: true, Timestamp: now}},
+		"fedora": {{IsUp: true, Timestamp: now}},
+		"arch":   {{IsUp: false, Message: "could not fetch history"}}, // no timestamp: unknown
+	}}
+
+	if changed := srv.transitions(nil); len(changed) != 0 {
+		t.Errorf("Expected no transitions on the first fetch, got %+v", changed)
+	}
+
+	changed := srv.transitions(map[string]bool{"debian": true, "ubuntu": false, "fedora": true, "arch": true})
+	if len(changed) != 2 {
+		t.Fatalf("Expected debian and ubuntu to change, got %+v", changed)
+	}
+	if changed[0].module != "debian" || changed[0].up || changed[0].err != "@ERROR: max connections (10) reached" {
+		t.Errorf("Expected debian going down with its error, got %+v", changed[0])
+	}
+	if changed[1].module != "ubuntu" || !changed[1].up {
+		t.Errorf("Expected ubuntu coming back up, got %+v", changed[1])
+	}
+
+	title, body := notificationText(changed[:1], false)
+	if title != "debian is down" || body != "@ERROR: max connections (10) reached" {
+		t.Errorf("Unexpected notification for one module: %q %q", title, body)
+	}
+	title, body = notificationText(changed, true)
+	if title != "1 modules down, 1 back up" || body != "Down: debian @ mirror\nUp: ubuntu @ mirror" {
+		t.Errorf("Unexpected notification for several modules: %q %q", title, body)
+	}
+}